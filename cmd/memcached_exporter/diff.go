@@ -0,0 +1,56 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+// diffSettings queries the settings of servers through e and writes a table of
+// those that differ to w. It reports whether any differ.
+func diffSettings(w io.Writer, e *exporter.Exporter, servers []string) (bool, error) {
+	settings := make(map[string]map[string]string, len(servers))
+	for _, server := range servers {
+		s, err := e.Settings(server)
+		if err != nil {
+			return false, fmt.Errorf("querying settings of %s: %w", server, err)
+		}
+		settings[server] = s
+	}
+
+	diff := exporter.DiffSettings(settings)
+	if len(diff) == 0 {
+		fmt.Fprintf(w, "The settings of the %d servers are the same.\n", len(servers))
+		return false, nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "SETTING\t%s\n", strings.Join(servers, "\t"))
+	for _, name := range diff {
+		values := make([]string, 0, len(servers))
+		for _, server := range servers {
+			value, ok := settings[server][name]
+			if !ok {
+				value = "-"
+			}
+			values = append(values, value)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", name, strings.Join(values, "\t"))
+	}
+	return true, tw.Flush()
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+func TestDiffSettings(t *testing.T) {
+	statsA := memcachedtest.Stats()
+	statsA[""]["version"] = "1.6.21"
+	a := memcachedtest.NewServer(t, statsA)
+	statsB := memcachedtest.Stats()
+	statsB[""]["version"] = "1.6.22"
+	b := memcachedtest.NewServer(t, statsB)
+	e := exporter.New("", time.Second, log.NewNopLogger(), nil)
+
+	var buf bytes.Buffer
+	differ, err := diffSettings(&buf, e, []string{a.Addr, a.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differ {
+		t.Errorf("want no differences, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	differ, err = diffSettings(&buf, e, []string{a.Addr, b.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !differ {
+		t.Error("want differences")
+	}
+	if want := `(?m)^version\s+1\.6\.21\s+1\.6\.22$`; !regexp.MustCompile(want).MatchString(buf.String()) {
+		t.Errorf("want %q in output:\n%s", want, buf.String())
+	}
+	if want := `maxconns`; regexp.MustCompile(want).MatchString(buf.String()) {
+		t.Errorf("want no %q in output:\n%s", want, buf.String())
+	}
+
+	if _, err := diffSettings(&buf, e, []string{a.Addr, "127.0.0.1:1"}); err == nil {
+		t.Error("want error for a server that is down")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Output formats of the dump command.
+const (
+	formatText        = "text"
+	formatJSON        = "json"
+	formatOpenMetrics = "openmetrics"
+)
+
+// jsonFamily is a metric family in the JSON output of the dump command.
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+// jsonMetric is a sample in the JSON output of the dump command. Histograms
+// and summaries are reduced to their count and sum.
+type jsonMetric struct {
+	Labels map[string]string `json:"labels"`
+	Value  *float64          `json:"value,omitempty"`
+	Count  *uint64           `json:"count,omitempty"`
+	Sum    *float64          `json:"sum,omitempty"`
+}
+
+// dump collects c once and writes the metrics to w in the given format. It
+// reports whether all servers were up.
+func dump(w io.Writer, c prometheus.Collector, format string) (bool, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		return false, err
+	}
+	mfs, gatherErr := registry.Gather()
+
+	var err error
+	switch format {
+	case formatText:
+		for _, mf := range mfs {
+			if _, err = expfmt.MetricFamilyToText(w, mf); err != nil {
+				break
+			}
+		}
+	case formatOpenMetrics:
+		for _, mf := range mfs {
+			if _, err = expfmt.MetricFamilyToOpenMetrics(w, mf); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			_, err = expfmt.FinalizeOpenMetrics(w)
+		}
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(jsonFamilies(mfs))
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return false, err
+	}
+	return allUp(mfs), gatherErr
+}
+
+// jsonFamilies converts mfs for the JSON output. Samples that are not finite
+// cannot be represented in JSON and are left out.
+func jsonFamilies(mfs []*dto.MetricFamily) []jsonFamily {
+	families := make([]jsonFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		family := jsonFamily{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    strings.ToLower(mf.GetType().String()),
+			Metrics: make([]jsonMetric, 0, len(mf.Metric)),
+		}
+		for _, m := range mf.Metric {
+			metric := jsonMetric{Labels: map[string]string{}}
+			for _, lp := range m.Label {
+				metric.Labels[lp.GetName()] = lp.GetValue()
+			}
+			var value float64
+			switch {
+			case m.Counter != nil:
+				value = m.GetCounter().GetValue()
+				metric.Value = &value
+			case m.Gauge != nil:
+				value = m.GetGauge().GetValue()
+				metric.Value = &value
+			case m.Untyped != nil:
+				value = m.GetUntyped().GetValue()
+				metric.Value = &value
+			case m.Histogram != nil:
+				metric.Count, metric.Sum = m.Histogram.SampleCount, m.Histogram.SampleSum
+			case m.Summary != nil:
+				metric.Count, metric.Sum = m.Summary.SampleCount, m.Summary.SampleSum
+			}
+			if metric.Value != nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+				continue
+			}
+			family.Metrics = append(family.Metrics, metric)
+		}
+		families = append(families, family)
+	}
+	return families
+}
+
+// allUp reports whether mfs has memcached_up samples that are all 1.
+func allUp(mfs []*dto.MetricFamily) bool {
+	for _, mf := range mfs {
+		if mf.GetName() != "memcached_up" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			if m.GetGauge().GetValue() != 1 {
+				return false
+			}
+		}
+		return len(mf.Metric) > 0
+	}
+	return false
+}
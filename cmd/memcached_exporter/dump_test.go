@@ -0,0 +1,97 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+func TestDump(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e := exporter.New(server.Addr, time.Second, log.NewNopLogger(), nil)
+
+	t.Run("Text", func(t *testing.T) {
+		var buf bytes.Buffer
+		up, err := dump(&buf, e, formatText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !up {
+			t.Error("want up")
+		}
+		if want := `memcached_up{server="` + server.Addr + `"} 1`; !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q in output:\n%s", want, buf.String())
+		}
+	})
+
+	t.Run("OpenMetrics", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := dump(&buf, e, formatOpenMetrics); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(buf.String(), "# EOF\n") {
+			t.Errorf("want output ending in # EOF:\n%s", buf.String())
+		}
+		if want := "# TYPE memcached_commands counter"; !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q in output:\n%s", want, buf.String())
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := dump(&buf, e, formatJSON); err != nil {
+			t.Fatal(err)
+		}
+		var families []jsonFamily
+		if err := json.Unmarshal(buf.Bytes(), &families); err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, f := range families {
+			if f.Name != "memcached_up" {
+				continue
+			}
+			found = true
+			if f.Type != "gauge" || len(f.Metrics) != 1 || *f.Metrics[0].Value != 1 || f.Metrics[0].Labels["server"] != server.Addr {
+				t.Errorf("unexpected memcached_up family: %+v", f)
+			}
+		}
+		if !found {
+			t.Errorf("want memcached_up in output:\n%s", buf.String())
+		}
+	})
+
+	t.Run("Down", func(t *testing.T) {
+		down := exporter.New("127.0.0.1:1", time.Second, log.NewNopLogger(), nil)
+		var buf bytes.Buffer
+		up, err := dump(&buf, down, formatText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if up {
+			t.Error("want down")
+		}
+		if want := `memcached_up{server="127.0.0.1:1"} 0`; !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q in output:\n%s", want, buf.String())
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+// healthyHandler answers as long as the exporter serves requests.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Healthy.")
+}
+
+// readyHandler answers with 503 Service Unavailable unless at least minServers
+// servers of e, or all of them if minServers is 0, answered within interval.
+// Without e, the exporter only serves scrapes and is always ready.
+func readyHandler(e *exporter.Exporter, minServers int, interval time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e == nil {
+			fmt.Fprintln(w, "Ready.")
+			return
+		}
+		answering, total := e.Answering(interval)
+		required := minServers
+		if required == 0 || required > total {
+			required = total
+		}
+		if answering < required {
+			http.Error(w, fmt.Sprintf("Not ready: %d of %d servers answered, %d required.", answering, total, required), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "Ready: %d of %d servers answered.\n", answering, total)
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+func TestReadyHandler(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e := exporter.New(server.Addr+",127.0.0.1:1", time.Second, log.NewNopLogger(), nil)
+	testutil.CollectAndCount(e)
+
+	for _, tc := range []struct {
+		e          *exporter.Exporter
+		minServers int
+		want       int
+	}{
+		{e, 1, http.StatusOK},
+		{e, 2, http.StatusServiceUnavailable},
+		{e, 0, http.StatusServiceUnavailable},
+		{e, 3, http.StatusServiceUnavailable},
+		{nil, 0, http.StatusOK},
+	} {
+		rr := httptest.NewRecorder()
+		readyHandler(tc.e, tc.minServers, time.Minute).ServeHTTP(rr, httptest.NewRequest("GET", "/-/ready", nil))
+		if rr.Code != tc.want {
+			t.Errorf("min servers %d: handler returned wrong status code: got %d, want: %d", tc.minServers, rr.Code, tc.want)
+		}
+	}
+
+	e = exporter.New(server.Addr, time.Second, log.NewNopLogger(), nil)
+	testutil.CollectAndCount(e)
+	rr := httptest.NewRecorder()
+	readyHandler(e, 0, time.Minute).ServeHTTP(rr, httptest.NewRequest("GET", "/-/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusOK)
+	}
+}
@@ -14,16 +14,23 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
+	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	promconfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/promlog"
@@ -32,7 +39,14 @@ import (
 	"github.com/prometheus/exporter-toolkit/web"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/connpool"
+	"github.com/tdewolff/memcached_exporter/discovery"
+	"github.com/tdewolff/memcached_exporter/graphite"
+	"github.com/tdewolff/memcached_exporter/internal/exposition"
+	"github.com/tdewolff/memcached_exporter/otlp"
 	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+	"github.com/tdewolff/memcached_exporter/remotewrite"
 	"github.com/tdewolff/memcached_exporter/scraper"
 )
 
@@ -41,26 +55,74 @@ func main() {
 		address            = kingpin.Flag("memcached.address", "Memcached server address.").Default("localhost:11211").String()
 		timeout            = kingpin.Flag("memcached.timeout", "memcached connect timeout.").Default("1s").Duration()
 		pidFile            = kingpin.Flag("memcached.pid-file", "Optional path to a file containing the memcached PID for additional metrics.").Default("").String()
+		discoverLocal      = kingpin.Flag("memcached.discover-local", "Collect the memcached servers running on this host, found in --path.procfs, instead of --memcached.address.").Bool()
+		discoverInterval   = kingpin.Flag("memcached.discover-local.interval", "Interval between two scans for the memcached servers running on this host.").Default("30s").Duration()
+		procfsPath         = kingpin.Flag("path.procfs", "Mount point of the proc filesystem.").Default("/proc").String()
+		cgroupfsPath       = kingpin.Flag("path.cgroupfs", "Mount point of the cgroup filesystem.").Default("/sys/fs/cgroup").String()
 		enableTLS          = kingpin.Flag("memcached.tls.enable", "Enable TLS connections to memcached").Bool()
 		certFile           = kingpin.Flag("memcached.tls.cert-file", "Client certificate file.").Default("").String()
 		keyFile            = kingpin.Flag("memcached.tls.key-file", "Client private key file.").Default("").String()
 		caFile             = kingpin.Flag("memcached.tls.ca-file", "Client root CA file.").Default("").String()
 		insecureSkipVerify = kingpin.Flag("memcached.tls.insecure-skip-verify", "Skip server certificate verification").Bool()
 		serverName         = kingpin.Flag("memcached.tls.server-name", "Memcached TLS certificate servername").Default("").String()
+		idleTimeout        = kingpin.Flag("memcached.pool.idle-timeout", "Close connections to memcached that have been idle for this long. 0 opens new connections on every scrape.").Default("5m").Duration()
+		excludeOwnConns    = kingpin.Flag("memcached.exclude-own-connections", "Subtract the exporter's own connections from the reported connection metrics.").Bool()
+		minRefreshInterval = kingpin.Flag("memcached.min-refresh-interval", "Serve cached samples of a server that was collected less than this long ago. 0 disables the cache.").Default("0s").Duration()
+		collectInterval    = kingpin.Flag("collect.interval", "Poll the servers of --memcached.address in the background at this interval and serve the latest results. 0 queries them on every scrape.").Default("0s").Duration()
+		settingsInterval   = kingpin.Flag("collect.interval.settings", "Minimum interval between two queries of the settings stats when polling.").Default("0s").Duration()
+		slabsInterval      = kingpin.Flag("collect.interval.slabs", "Minimum interval between two queries of the slabs stats when polling.").Default("0s").Duration()
+		itemsInterval      = kingpin.Flag("collect.interval.items", "Minimum interval between two queries of the items stats when polling.").Default("0s").Duration()
+		maxConcurrency     = kingpin.Flag("memcached.max-concurrency", "Maximum number of servers of --memcached.address collected at the same time. 0 means no limit.").Default("0").Int()
+		scrapeConcurrency  = kingpin.Flag("scrape.max-concurrency", "Maximum number of targets collected at the same time on --web.scrape-path. 0 means no limit.").Default("0").Int()
+		targetConcurrency  = kingpin.Flag("scrape.max-concurrency-per-target", "Maximum number of collections of the same target running at the same time on --web.scrape-path. 0 means no limit.").Default("0").Int()
+		scrapeQueue        = kingpin.Flag("scrape.max-queued", "Maximum number of scrapes waiting for the concurrency limits, beyond which scrapes are rejected with 503.").Default("100").Int()
+		otlpEndpoint       = kingpin.Flag("otlp.endpoint", "Push the metrics of --memcached.address to this OTLP/HTTP metrics endpoint, e.g. http://localhost:4318/v1/metrics.").Default("").String()
+		otlpInterval       = kingpin.Flag("otlp.interval", "Interval between two pushes to --otlp.endpoint.").Default("30s").Duration()
+		otlpHeaders        = kingpin.Flag("otlp.header", "Header of the pushes to --otlp.endpoint, as name=value. May be repeated.").StringMap()
+		otlpAttributes     = kingpin.Flag("otlp.resource-attribute", "Resource attribute of the metrics pushed to --otlp.endpoint, as name=value. May be repeated.").StringMap()
+		enabledCollectors  = map[string]*bool{}
 		webConfig          = webflag.AddFlags(kingpin.CommandLine, ":9150")
 		metricsPath        = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
 		scrapePath         = kingpin.Flag("web.scrape-path", "Path under which to receive scrape requests.").Default("/scrape").String()
+		configFile         = kingpin.Flag("config.file", "Optional path to a configuration file.").Default("").String()
+		readyMinServers    = kingpin.Flag("web.ready.min-servers", "Number of servers of --memcached.address that must have answered for /-/ready to succeed. 0 requires all of them.").Default("1").Int()
+		createdSamples     = kingpin.Flag("web.openmetrics.created-samples", "Serve the created timestamps of counters as _created samples to clients accepting OpenMetrics.").Default("false").Bool()
+		readyInterval      = kingpin.Flag("web.ready.interval", "Interval within which servers must have answered for /-/ready to succeed.").Default("1m").Duration()
+		gracePeriod        = kingpin.Flag("web.shutdown-grace-period", "Time given to in-flight scrapes and background collections to finish on SIGTERM or SIGINT.").Default("30s").Duration()
+
+		_          = kingpin.Command("serve", "Serve the metrics over HTTP.").Default()
+		dumpCmd    = kingpin.Command("dump", "Collect the metrics once and print them. Exits non-zero if a server is down.")
+		dumpTarget = dumpCmd.Flag("target", "Memcached server address to collect, instead of --memcached.address.").String()
+		dumpFormat = dumpCmd.Flag("format", "Output format: text, json or openmetrics.").Default(formatText).Enum(formatText, formatJSON, formatOpenMetrics)
+		topCmd     = kingpin.Command("top", "Show a live view of the activity of a server in the terminal.")
+		topTarget  = topCmd.Flag("target", "Memcached server address to watch, instead of --memcached.address.").String()
+		topRefresh = topCmd.Flag("interval", "Interval between two refreshes of the view.").Default("2s").Duration()
+		diffCmd    = kingpin.Command("diff", "Print the settings that differ between servers. Exits non-zero if any differ.")
+		diffTarget = diffCmd.Arg("server", "Memcached server addresses to compare.").Required().Strings()
 	)
 
+	for _, name := range exporter.Collectors {
+		enabledCollectors[name] = kingpin.Flag("collector."+name, "Enable the "+name+" collector.").Default("true").Bool()
+	}
+
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.HelpFlag.Short('h')
 	kingpin.Version(version.Print("memcached_exporter"))
-	kingpin.Parse()
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
-	level.Info(logger).Log("msg", "Starting memcached_exporter", "version", version.Info())
-	level.Info(logger).Log("msg", "Build context", "context", version.BuildContext())
+	if *readyMinServers < 0 {
+		level.Error(logger).Log("msg", "--web.ready.min-servers must not be negative")
+		os.Exit(1)
+	}
+
+	switch {
+	case command == dumpCmd.FullCommand() && *dumpTarget != "":
+		*address = *dumpTarget
+	case command == topCmd.FullCommand() && *topTarget != "":
+		*address = *topTarget
+	}
 
 	var (
 		tlsConfig *tls.Config
@@ -93,13 +155,248 @@ func main() {
 		}
 	}
 
-	prometheus.MustRegister(version.NewCollector("memcached_exporter"))
+	cfg := &config.Config{}
+	if *configFile != "" {
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading config", "err", err)
+			os.Exit(1)
+		}
+	}
+	if *pidFile != "" {
+		// Discovered servers are linked to their pids already.
+		if *discoverLocal {
+			level.Error(logger).Log("msg", "--memcached.pid-file cannot be combined with --memcached.discover-local")
+			os.Exit(1)
+		}
+		for _, t := range cfg.Targets {
+			if t.PIDFile != "" {
+				level.Error(logger).Log("msg", "--memcached.pid-file cannot be combined with pid_file in targets")
+				os.Exit(1)
+			}
+		}
+	}
+	var collectorNames []string
+	for _, name := range exporter.Collectors {
+		if *enabledCollectors[name] {
+			collectorNames = append(collectorNames, name)
+		}
+	}
+
+	connPool := connpool.New(*timeout, tlsConfig, *idleTimeout)
+	exporterOptions := []exporter.Option{
+		exporter.WithCollectors(collectorNames...),
+		exporter.WithTargets(cfg.Targets),
+		exporter.WithConnPool(connPool),
+		exporter.WithExcludeOwnConnections(*excludeOwnConns),
+		exporter.WithProcFS(*procfsPath),
+		exporter.WithCgroupFS(*cgroupfsPath),
+	}
+	if *minRefreshInterval > 0 {
+		exporterOptions = append(exporterOptions, exporter.WithCache(exporter.NewCache(*minRefreshInterval)))
+	}
+
+	switch command {
+	case dumpCmd.FullCommand():
+		e := exporter.New(*address, *timeout, logger, tlsConfig, exporterOptions...)
+		up, err := dump(os.Stdout, e, *dumpFormat)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error collecting metrics", "err", err)
+		}
+		if !up {
+			os.Exit(1)
+		}
+		return
+	case topCmd.FullCommand():
+		// Connection errors are shown in the view rather than logged over it.
+		e := exporter.New(*address, *timeout, log.NewNopLogger(), tlsConfig, exporterOptions...)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := top(ctx, os.Stdout, e, *address, *topRefresh); err != nil {
+			level.Error(logger).Log("msg", "Error showing metrics", "err", err)
+			os.Exit(1)
+		}
+		return
+	case diffCmd.FullCommand():
+		e := exporter.New("", *timeout, logger, tlsConfig, exporterOptions...)
+		differ, err := diffSettings(os.Stdout, e, *diffTarget)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error comparing settings", "err", err)
+			os.Exit(1)
+		}
+		if differ {
+			os.Exit(1)
+		}
+		return
+	}
+
+	level.Info(logger).Log("msg", "Starting memcached_exporter", "version", version.Info())
+	level.Info(logger).Log("msg", "Build context", "context", version.BuildContext())
 
-	if *address != "" {
-		prometheus.MustRegister(exporter.New(*address, *timeout, logger, tlsConfig))
+	// Background tasks stop once ctx is done, and are waited for on shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal terminates the exporter immediately.
+		<-ctx.Done()
+		stop()
+	}()
+	var background sync.WaitGroup
+	run := func(f func(ctx context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			f(ctx)
+		}()
 	}
+	pools := []*connpool.Pool{connPool}
 
-	if *pidFile != "" {
+	scraperOptions := []scraper.Option{
+		scraper.WithExporterOptions(exporterOptions...),
+		scraper.WithLimits(*scrapeConcurrency, *targetConcurrency, *scrapeQueue),
+		scraper.WithModules(cfg.Modules),
+		scraper.WithTargets(cfg.Targets),
+		scraper.WithStaticTargets(strings.Split(*address, ",")),
+		scraper.WithCreatedSamples(*createdSamples),
+	}
+	if cfg.Scrape.Allow != nil {
+		// Scraped targets get their own connections, which are checked
+		// against the allowlist once their address is resolved.
+		allowlist := scraper.NewAllowlist(*cfg.Scrape.Allow)
+		scraperPool := connpool.New(*timeout, tlsConfig, *idleTimeout, connpool.WithDialControl(allowlist.Control))
+		pools = append(pools, scraperPool)
+		scraperOptions = append(scraperOptions,
+			scraper.WithAllowlist(allowlist),
+			scraper.WithExporterOptions(exporter.WithConnPool(scraperPool)),
+		)
+	}
+	scraper := scraper.New(*timeout, logger, tlsConfig, scraperOptions...)
+
+	prometheus.MustRegister(versioncollector.NewCollector("memcached_exporter"))
+	prometheus.MustRegister(scraper)
+
+	var e *exporter.Exporter
+	if *address != "" || *discoverLocal {
+		opts := append(exporterOptions,
+			exporter.WithPools(cfg.Pools),
+			exporter.WithObserver(scraper),
+			exporter.WithMaxConcurrency(*maxConcurrency),
+			exporter.WithPIDFile(*pidFile),
+		)
+		if *collectInterval > 0 {
+			opts = append(opts,
+				exporter.WithPolling(*collectInterval),
+				exporter.WithCommandIntervals(map[string]time.Duration{
+					"settings": *settingsInterval,
+					"slabs":    *slabsInterval,
+					"items":    *itemsInterval,
+				}),
+			)
+		}
+		if *discoverLocal {
+			instances, err := discovery.Scan(*procfsPath)
+			if err != nil {
+				level.Error(logger).Log("msg", "Error discovering local memcached servers", "err", err)
+				os.Exit(1)
+			}
+			level.Info(logger).Log("msg", "Discovered local memcached servers", "servers", len(instances))
+			e = exporter.New("", *timeout, logger, tlsConfig, opts...)
+			setInstances(e, instances)
+			run(func(ctx context.Context) {
+				discovery.Watch(ctx, *procfsPath, *discoverInterval, logger, instances, func(instances []discovery.Instance) {
+					setInstances(e, instances)
+				})
+			})
+		} else {
+			e = exporter.New(*address, *timeout, logger, tlsConfig, opts...)
+		}
+		if *pidFile != "" && len(e.Addresses()) > 1 {
+			level.Warn(logger).Log("msg", "--memcached.pid-file is ignored with several servers, use pid_file in targets instead")
+		}
+		prometheus.MustRegister(e)
+		if *collectInterval > 0 {
+			run(e.Poll)
+		}
+		run(func(ctx context.Context) { e.Probe(ctx, *readyInterval) })
+	}
+
+	// The pushers share the gathers of e, so that the load on memcached does
+	// not grow with the number of sinks.
+	var pushIntervals []time.Duration
+	if *otlpEndpoint != "" {
+		pushIntervals = append(pushIntervals, *otlpInterval)
+	}
+	if rw := cfg.RemoteWrite; rw != nil {
+		pushIntervals = append(pushIntervals, time.Duration(rw.Interval))
+	}
+	if g := cfg.Graphite; g != nil {
+		pushIntervals = append(pushIntervals, time.Duration(g.Interval))
+	}
+	// A nil *pushGatherer would be a non-nil prometheus.Gatherer.
+	var pushed prometheus.Gatherer
+	if e != nil && len(pushIntervals) > 0 {
+		pushed = newPushGatherer(e, minInterval(pushIntervals...))
+	}
+
+	if *otlpEndpoint != "" {
+		if e == nil {
+			level.Error(logger).Log("msg", "--otlp.endpoint requires --memcached.address")
+			os.Exit(1)
+		}
+		pusher := otlp.New(*otlpEndpoint, pushed, logger,
+			otlp.WithHeaders(*otlpHeaders),
+			otlp.WithResourceAttributes(*otlpAttributes),
+		)
+		run(func(ctx context.Context) { pusher.Run(ctx, *otlpInterval) })
+	}
+
+	if rw := cfg.RemoteWrite; rw != nil {
+		var targets prometheus.Collector
+		if len(rw.Targets) > 0 {
+			opts := append(append([]exporter.Option{}, exporterOptions...), exporter.WithObserver(scraper))
+			targets = exporter.New(strings.Join(rw.Targets, ","), *timeout, logger, tlsConfig, opts...)
+		}
+		gatherer, err := remoteWriteGatherer(pushed, targets)
+		if err != nil {
+			level.Error(logger).Log("msg", "Failed to register the remote-write targets", "err", err)
+			os.Exit(1)
+		}
+		client, err := promconfig.NewClientFromConfig(rw.HTTPClientConfig, "remote_write")
+		if err != nil {
+			level.Error(logger).Log("msg", "Failed to create remote-write client", "err", err)
+			os.Exit(1)
+		}
+		sender := remotewrite.New(rw.URL, gatherer, logger,
+			remotewrite.WithClient(client),
+			remotewrite.WithExternalLabels(rw.ExternalLabels),
+			remotewrite.WithMaxQueued(rw.MaxQueuedRequests),
+			remotewrite.WithBackoff(time.Duration(rw.MinBackoff), time.Duration(rw.MaxBackoff)),
+		)
+		prometheus.MustRegister(sender)
+		run(func(ctx context.Context) { sender.Run(ctx, time.Duration(rw.Interval)) })
+	}
+
+	if g := cfg.Graphite; g != nil {
+		if e == nil {
+			level.Error(logger).Log("msg", "Pushing to Graphite requires --memcached.address")
+			os.Exit(1)
+		}
+		converter, err := graphite.NewConverter(g.Templates, g.LabelOrder)
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid Graphite configuration", "err", err)
+			os.Exit(1)
+		}
+		pusher := graphite.New(g.Address, pushed, converter, logger,
+			graphite.WithTimeout(time.Duration(g.Timeout)),
+			graphite.WithMaxBuffered(g.MaxBufferedLines),
+		)
+		prometheus.MustRegister(pusher)
+		run(func(ctx context.Context) { pusher.Run(ctx, time.Duration(g.Interval)) })
+	}
+
+	// With --memcached.address, the process metrics are collected by the
+	// exporter, labelled with the server.
+	if *pidFile != "" && e == nil {
 		procExporter := collectors.NewProcessCollector(collectors.ProcessCollectorOpts{
 			PidFn:     prometheus.NewPidFileFn(*pidFile),
 			Namespace: exporter.Namespace,
@@ -107,9 +404,11 @@ func main() {
 		prometheus.MustRegister(procExporter)
 	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
-	scraper := scraper.New(*timeout, logger, tlsConfig)
+	http.Handle(*metricsPath, metricsHandler(e, *createdSamples, logger))
 	http.Handle(*scrapePath, scraper.Handler())
+	http.Handle("/debug/stats", scraper.DebugHandler())
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.Handle("/-/ready", readyHandler(e, *readyMinServers, *readyInterval))
 
 	if *metricsPath != "/" && *metricsPath != "" {
 		landingConfig := web.LandingConfig{
@@ -132,8 +431,55 @@ func main() {
 	}
 
 	srv := &http.Server{}
-	if err := web.ListenAndServe(srv, webConfig, logger); err != nil {
+	err = serveUntil(ctx, srv, func() error {
+		return web.ListenAndServe(srv, webConfig, logger)
+	}, &background, *gracePeriod, logger)
+	// Connections still in use are closed when they are returned.
+	for _, p := range pools {
+		p.Close()
+	}
+	if err != nil {
 		level.Error(logger).Log("msg", "Error running HTTP server", "err", err)
 		os.Exit(1)
 	}
 }
+
+// metricsHandler serves the metrics of the default registry, or only those of
+// the collectors of e listed in the collect[] parameters, if any. If
+// createdSamples is true, clients that accept OpenMetrics get the created
+// timestamps as _created samples.
+func metricsHandler(e *exporter.Exporter, createdSamples bool, logger log.Logger) http.Handler {
+	handler := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, exposition.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{}, createdSamples),
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["collect[]"]
+		if len(names) == 0 || e == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		filtered, err := e.Filter(names)
+		if err != nil {
+			level.Warn(logger).Log("msg", "Invalid collect[] parameter", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(filtered)
+		opts := promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}
+		exposition.HandlerFor(registry, opts, createdSamples).ServeHTTP(w, r)
+	})
+}
+
+// setInstances makes e collect the discovered instances, linking their
+// addresses to their pids for the process collector.
+func setInstances(e *exporter.Exporter, instances []discovery.Instance) {
+	addresses := make([]string, 0, len(instances))
+	pids := make(map[string]int, len(instances))
+	for _, instance := range instances {
+		addresses = append(addresses, instance.Address)
+		pids[instance.Address] = instance.PID
+	}
+	e.SetAddresses(addresses, pids)
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"strconv"
@@ -25,7 +26,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-kit/log"
 	"github.com/grobie/gomemcache/memcache"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
 )
 
 func waitExporterReady(t *testing.T, errorChannel chan error, address string) {
@@ -80,6 +84,36 @@ func warmUpMemcached(t *testing.T, client *memcache.Client) {
 	}
 }
 
+func TestMetricsHandlerOpenMetrics(t *testing.T) {
+	stats := memcachedtest.Stats()
+	stats[""]["time"] = "1700003600"
+	server := memcachedtest.NewServer(t, stats)
+	e := exporter.New(server.Addr, time.Second, log.NewNopLogger(), nil)
+	created := `memcached_connections_created{server="` + server.Addr + `"} 1.7e+09`
+
+	for _, createdSamples := range []bool{false, true} {
+		req := httptest.NewRequest("GET", "/metrics?collect[]=general", nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		rr := httptest.NewRecorder()
+		metricsHandler(e, createdSamples, log.NewNopLogger()).ServeHTTP(rr, req)
+		if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+			t.Fatalf("got content type %q, want OpenMetrics", contentType)
+		}
+
+		body := rr.Body.String()
+		if !strings.HasSuffix(body, "# EOF\n") {
+			t.Fatalf("response does not end with # EOF. body: %s", body)
+		}
+		found := false
+		for _, line := range strings.Split(body, "\n") {
+			found = found || line == created
+		}
+		if found != createdSamples {
+			t.Errorf("created samples %v: response has %s sample %v. body: %s", createdSamples, created, found, body)
+		}
+	}
+}
+
 func TestAcceptanceSingleInstance(t *testing.T) {
 	errc := make(chan error)
 
@@ -0,0 +1,70 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushGatherer gathers the metrics of an exporter for the pushers to OTLP,
+// remote write and Graphite. Gathers within maxAge of the previous one share
+// its result, so that pushers firing together query memcached once rather than
+// once per sink.
+type pushGatherer struct {
+	registry *prometheus.Registry
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mutex sync.Mutex
+	time  time.Time
+	mfs   []*dto.MetricFamily
+	err   error
+}
+
+// newPushGatherer returns the gatherer of c shared by pushers running every
+// interval or more. Results are shared for half of interval, so that a pusher
+// never gets the result of its own previous push, even if its ticks drift.
+func newPushGatherer(c prometheus.Collector, interval time.Duration) *pushGatherer {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	return &pushGatherer{registry: registry, maxAge: interval / 2, now: time.Now}
+}
+
+// Gather implements prometheus.Gatherer. Concurrent calls wait for a single
+// gather.
+func (g *pushGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	now := g.now()
+	if g.time.IsZero() || now.Sub(g.time) >= g.maxAge {
+		g.mfs, g.err = g.registry.Gather()
+		g.time = now
+	}
+	return g.mfs, g.err
+}
+
+// minInterval returns the shortest of intervals, or 0 if there are none.
+func minInterval(intervals ...time.Duration) time.Duration {
+	var min time.Duration
+	for _, interval := range intervals {
+		if min == 0 || interval < min {
+			min = interval
+		}
+	}
+	return min
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+func TestPushGatherer(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e := exporter.New(server.Addr, time.Second, log.NewNopLogger(), nil)
+	g := newPushGatherer(e, minInterval(time.Minute, 30*time.Second))
+	now := time.Unix(1700000000, 0)
+	g.now = func() time.Time { return now }
+
+	stats := func() int {
+		n := 0
+		for _, command := range server.Commands() {
+			if command == "stats" {
+				n++
+			}
+		}
+		return n
+	}
+	gather := func() {
+		t.Helper()
+		if _, err := g.Gather(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pushers firing together share a gather.
+	gather()
+	gather()
+	if n := stats(); n != 1 {
+		t.Errorf("got %d stats commands, want 1", n)
+	}
+
+	// The next push of the shortest interval gathers again.
+	now = now.Add(30 * time.Second)
+	gather()
+	if n := stats(); n != 2 {
+		t.Errorf("got %d stats commands, want 2", n)
+	}
+}
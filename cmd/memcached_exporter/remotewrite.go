@@ -0,0 +1,37 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// remoteWriteGatherer returns the gatherer of the metrics sent by remote
+// write, from own, the gatherer of the exporter of --memcached.address, and the
+// exporter of the remote-write targets, either of which may be nil. The targets
+// get their own registry, as they describe the same metrics.
+func remoteWriteGatherer(own prometheus.Gatherer, targets prometheus.Collector) (prometheus.Gatherer, error) {
+	var gatherers prometheus.Gatherers
+	if own != nil {
+		gatherers = append(gatherers, own)
+	}
+	if targets != nil {
+		registry := prometheus.NewRegistry()
+		if err := registry.Register(targets); err != nil {
+			return nil, err
+		}
+		gatherers = append(gatherers, registry)
+	}
+	return gatherers, nil
+}
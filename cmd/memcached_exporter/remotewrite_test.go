@@ -0,0 +1,58 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+func TestRemoteWriteGatherer(t *testing.T) {
+	a := memcachedtest.NewServer(t, memcachedtest.Stats())
+	b := memcachedtest.NewServer(t, memcachedtest.Stats())
+	// The exporter of --memcached.address and the one of the remote-write
+	// targets describe the same metrics.
+	e := exporter.New(a.Addr, time.Second, log.NewNopLogger(), nil)
+	targets := exporter.New(b.Addr, time.Second, log.NewNopLogger(), nil)
+
+	g, err := remoteWriteGatherer(newPushGatherer(e, 0), targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{server="A"} 1
+memcached_up{server="B"} 1
+`
+	expected = strings.NewReplacer(`"A"`, `"`+a.Addr+`"`, `"B"`, `"`+b.Addr+`"`).Replace(expected)
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected), "memcached_up"); err != nil {
+		t.Error(err)
+	}
+
+	// Without --memcached.address, only the targets are gathered.
+	g, err = remoteWriteGatherer(nil, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := testutil.GatherAndCount(g, "memcached_up"); err != nil || n != 1 {
+		t.Errorf("want 1 memcached_up, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// serveUntil serves srv with listenAndServe until ctx is done. It then stops
+// accepting requests, and waits for the in-flight requests and the background
+// tasks of wg, which must stop once ctx is done, to finish within gracePeriod.
+func serveUntil(ctx context.Context, srv *http.Server, listenAndServe func() error, wg *sync.WaitGroup, gracePeriod time.Duration, logger log.Logger) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	level.Info(logger).Log("msg", "Shutting down", "grace_period", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("in-flight requests did not finish within the grace period: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-shutdownCtx.Done():
+		return fmt.Errorf("background tasks did not finish within the grace period: %w", shutdownCtx.Err())
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+func TestServeUntil(t *testing.T) {
+	serve := func(t *testing.T, release <-chan struct{}, gracePeriod time.Duration) (resp chan error, stop func() error) {
+		t.Helper()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		started := make(chan struct{})
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			io.WriteString(w, "done")
+		})}
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ctx.Done()
+		}()
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- serveUntil(ctx, srv, func() error { return srv.Serve(listener) }, &wg, gracePeriod, log.NewNopLogger())
+		}()
+
+		resp = make(chan error, 1)
+		go func() {
+			r, err := http.Get("http://" + listener.Addr().String())
+			if err == nil {
+				_, err = io.ReadAll(r.Body)
+				r.Body.Close()
+			}
+			resp <- err
+		}()
+		<-started
+		return resp, func() error {
+			cancel()
+			return <-errCh
+		}
+	}
+
+	t.Run("Drain", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		resp, stop := serve(t, release, time.Minute)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+		}()
+		if err := stop(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := <-resp; err != nil {
+			t.Errorf("in-flight request failed: %v", err)
+		}
+	})
+
+	t.Run("GracePeriod", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		defer close(release)
+		_, stop := serve(t, release, 50*time.Millisecond)
+		if err := stop(); err == nil {
+			t.Error("want error for requests exceeding the grace period")
+		}
+	})
+}
@@ -0,0 +1,277 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// clearScreen moves the cursor to the top left corner and clears the terminal.
+const clearScreen = "\033[H\033[2J"
+
+// sample is a sample of a snapshot, with its labels.
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// snapshot holds the samples of one collection, by metric name.
+type snapshot struct {
+	time    time.Time
+	samples map[string][]sample
+}
+
+// takeSnapshot gathers the metrics of g.
+func takeSnapshot(g prometheus.Gatherer, now time.Time) (*snapshot, error) {
+	mfs, err := g.Gather()
+	s := &snapshot{time: now, samples: map[string][]sample{}}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			labels := make(map[string]string, len(m.Label))
+			for _, lp := range m.Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			s.samples[mf.GetName()] = append(s.samples[mf.GetName()], sample{labels: labels, value: metricValue(m)})
+		}
+	}
+	return s, err
+}
+
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+// sum returns the sum of the samples of name whose labels match, summing over
+// the servers of the target.
+func (s *snapshot) sum(name string, match map[string]string) float64 {
+	var sum float64
+	for _, smpl := range s.samples[name] {
+		if matches(smpl.labels, match) {
+			sum += smpl.value
+		}
+	}
+	return sum
+}
+
+// by returns the sums of the samples of name whose labels match, by the value
+// of label.
+func (s *snapshot) by(name, label string, match map[string]string) map[string]float64 {
+	sums := map[string]float64{}
+	for _, smpl := range s.samples[name] {
+		if v, ok := smpl.labels[label]; ok && matches(smpl.labels, match) {
+			sums[v] += smpl.value
+		}
+	}
+	return sums
+}
+
+func matches(labels, match map[string]string) bool {
+	for name, value := range match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// view renders the delta between two snapshots of a target.
+type view struct {
+	target    string
+	prev, cur *snapshot
+}
+
+// rate returns the per-second rate of a counter whose value went from prev to
+// cur. Without a previous snapshot, it is the average rate since the server
+// started. A counter that went back was reset by a restart.
+func (v *view) rate(prev, cur float64) float64 {
+	elapsed := v.cur.sum("memcached_uptime_seconds", nil)
+	if v.prev != nil {
+		elapsed = v.cur.time.Sub(v.prev.time).Seconds()
+		if delta := cur - prev; delta >= 0 {
+			cur = delta
+		}
+	}
+	if elapsed <= 0 {
+		return 0
+	}
+	return cur / elapsed
+}
+
+// counter returns the rate of the sum of the samples of name whose labels
+// match.
+func (v *view) counter(name string, match map[string]string) float64 {
+	var prev float64
+	if v.prev != nil {
+		prev = v.prev.sum(name, match)
+	}
+	return v.rate(prev, v.cur.sum(name, match))
+}
+
+// counterBy returns the rates of the sums of the samples of name, by the
+// value of label.
+func (v *view) counterBy(name, label string, match map[string]string) map[string]float64 {
+	var prev map[string]float64
+	if v.prev != nil {
+		prev = v.prev.by(name, label, match)
+	}
+	rates := map[string]float64{}
+	for value, cur := range v.cur.by(name, label, match) {
+		rates[value] = v.rate(prev[value], cur)
+	}
+	return rates
+}
+
+// render writes the view to w.
+func (v *view) render(w io.Writer) {
+	cur := v.cur
+	up := cur.sum("memcached_up", nil)
+	servers := float64(len(cur.samples["memcached_up"]))
+	period := "since start"
+	if v.prev != nil {
+		period = "over " + cur.time.Sub(v.prev.time).Round(time.Millisecond).String()
+	}
+	fmt.Fprintf(w, "memcached top - %s - %s\n", v.target, cur.time.Format("15:04:05"))
+	fmt.Fprintf(w, "Servers: %g/%g up   Uptime: %s   Rates %s\n",
+		up, servers, time.Duration(cur.sum("memcached_uptime_seconds", nil))*time.Second, period)
+
+	hits := v.counter("memcached_commands_total", map[string]string{"command": "get", "status": "hit"})
+	misses := v.counter("memcached_commands_total", map[string]string{"command": "get", "status": "miss"})
+	hitRatio := "-"
+	if hits+misses > 0 {
+		hitRatio = fmt.Sprintf("%.1f%%", 100*hits/(hits+misses))
+	}
+	fmt.Fprintf(w, "Connections: %g current, %g max, %.1f/s new\n",
+		cur.sum("memcached_current_connections", nil),
+		cur.sum("memcached_max_connections", nil),
+		v.counter("memcached_connections_total", nil))
+	fmt.Fprintf(w, "Hit ratio: %s   Evictions: %.1f/s   Items: %g   Memory: %s / %s\n",
+		hitRatio,
+		v.counter("memcached_items_evicted_total", nil),
+		cur.sum("memcached_current_items", nil),
+		formatBytes(cur.sum("memcached_current_bytes", nil)),
+		formatBytes(cur.sum("memcached_limit_bytes", nil)))
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "COMMAND\tOPS/S\t")
+	ops := v.counterBy("memcached_commands_total", "command", nil)
+	for _, command := range sortedKeys(ops) {
+		fmt.Fprintf(tw, "%s\t%.1f\t\n", command, ops[command])
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "SLAB\tCHUNK SIZE\tITEMS\tUTILIZATION\tAGE\tEVICTIONS/S\t")
+	chunkSizes := cur.by("memcached_slab_chunk_size_bytes", "slab", nil)
+	chunks := cur.by("memcached_slab_current_chunks", "slab", nil)
+	used := cur.by("memcached_slab_chunks_used", "slab", nil)
+	items := cur.by("memcached_slab_current_items", "slab", nil)
+	ages := cur.by("memcached_slab_items_age_seconds", "slab", nil)
+	evictions := v.counterBy("memcached_slab_items_evicted_total", "slab", nil)
+	slabs := map[string]float64{}
+	for slab := range chunkSizes {
+		slabs[slab] = 0
+	}
+	for slab := range items {
+		slabs[slab] = 0
+	}
+	for _, slab := range sortedKeys(slabs) {
+		utilization := "-"
+		if chunks[slab] > 0 {
+			utilization = fmt.Sprintf("%.1f%%", 100*used[slab]/chunks[slab])
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%g\t%s\t%s\t%.1f\t\n",
+			slab, formatBytes(chunkSizes[slab]), items[slab], utilization,
+			time.Duration(ages[slab])*time.Second, evictions[slab])
+	}
+	tw.Flush()
+}
+
+// sortedKeys returns the keys of m, numerically if they are numbers.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(keys[i])
+		b, errB := strconv.Atoi(keys[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func formatBytes(b float64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%g B", b)
+	}
+	exp := 0
+	for n := b / unit; n >= unit && exp < 4; n /= unit {
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", b/float64(uint64(1)<<(10*(exp+1))), "KMGTP"[exp])
+}
+
+// top collects c at every interval and redraws the view of the target on w
+// until ctx is done.
+func top(ctx context.Context, w io.Writer, c prometheus.Collector, target string, interval time.Duration) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	v := &view{target: target}
+	for {
+		// Collection errors show as servers down, so the view is drawn anyway.
+		cur, _ := takeSnapshot(registry, time.Now())
+		v.prev, v.cur = v.cur, cur
+
+		var buf bytes.Buffer
+		buf.WriteString(clearScreen)
+		v.render(&buf)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+func TestTopView(t *testing.T) {
+	stats := memcachedtest.Stats()
+	stats[""]["get_hits"] = "40"
+	stats[""]["get_misses"] = "10"
+	stats[""]["evictions"] = "0"
+	stats["slabs"]["1:total_chunks"] = "100"
+	stats["slabs"]["1:used_chunks"] = "25"
+	stats["items"]["items:1:age"] = "60"
+	stats["items"]["items:1:evicted"] = "0"
+	server := memcachedtest.NewServer(t, stats)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.New(server.Addr, time.Second, log.NewNopLogger(), nil))
+
+	now := time.Unix(1700000000, 0)
+	prev, err := takeSnapshot(registry, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats[""]["get_hits"] = "130"
+	stats[""]["get_misses"] = "20"
+	stats[""]["cmd_get"] = "105"
+	stats[""]["total_connections"] = "120"
+	stats[""]["evictions"] = "50"
+	server.SetStats("", stats[""])
+	stats["items"]["items:1:evicted"] = "50"
+	server.SetStats("items", stats["items"])
+	cur, err := takeSnapshot(registry, now.Add(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	v := &view{target: server.Addr, prev: prev, cur: cur}
+	v.render(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"Servers: 1/1 up",
+		"Rates over 10s",
+		`Connections: 10 current, 1024 max, 2\.0/s new`,
+		`Hit ratio: 90\.0%   Evictions: 5\.0/s`,
+		`(?m)^\s*get\s+10\.0\s*$`,
+		`(?m)^\s*1\s+96 B\s+3\s+25\.0%\s+1m0s\s+5\.0\s*$`,
+	} {
+		if !regexp.MustCompile(want).MatchString(out) {
+			t.Errorf("want %q in view:\n%s", want, out)
+		}
+	}
+
+	// Without a previous snapshot, rates are averaged since the start.
+	buf.Reset()
+	v = &view{target: server.Addr, cur: prev}
+	v.render(&buf)
+	if want := "Rates since start"; !strings.Contains(buf.String(), want) {
+		t.Errorf("want %q in view:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,491 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// reservedLabels are the label names already used by the exporter's metrics.
+var reservedLabels = map[string]bool{
+	"server":      true,
+	"slab":        true,
+	"command":     true,
+	"status":      true,
+	"version":     true,
+	"lru":         true,
+	"pool":        true,
+	"stat":        true,
+	"size_bucket": true,
+	"type":        true,
+}
+
+// Hashing algorithms of a client pool.
+const (
+	HashKetama = "ketama"
+	HashModula = "modula"
+)
+
+// Config is the configuration file of the memcached exporter.
+type Config struct {
+	Targets     []TargetConfig          `yaml:"targets,omitempty"`
+	Pools       []PoolConfig            `yaml:"pools,omitempty"`
+	Scrape      ScrapeConfig            `yaml:"scrape,omitempty"`
+	Modules     map[string]ModuleConfig `yaml:"modules,omitempty"`
+	RemoteWrite *RemoteWriteConfig      `yaml:"remote_write,omitempty"`
+	Graphite    *GraphiteConfig         `yaml:"graphite,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, pool := range c.Pools {
+		if names[pool.Name] {
+			return fmt.Errorf("duplicate pool name %q", pool.Name)
+		}
+		names[pool.Name] = true
+	}
+	return nil
+}
+
+// TargetConfig describes how the metrics of the servers whose address matches
+// Match are labelled. Named capture groups of Match are added as labels, and
+// may be referenced as $name in Alias and Labels.
+type TargetConfig struct {
+	Match  Regexp            `yaml:"match"`
+	Alias  string            `yaml:"alias,omitempty"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+	Slabs  *SlabsConfig      `yaml:"slabs,omitempty"`
+	// PIDFile is the path or glob of the pid file of the matching servers,
+	// expanded with the submatches of Match.
+	PIDFile string `yaml:"pid_file,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *TargetConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TargetConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Match.Regexp == nil {
+		return fmt.Errorf("target is missing match")
+	}
+	for _, name := range c.LabelNames() {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if reservedLabels[name] {
+			return fmt.Errorf("label name %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// LabelNames returns the sorted names of the additional labels of the target:
+// the named capture groups of Match and the keys of Labels.
+func (c *TargetConfig) LabelNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range c.Match.SubexpNames() {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range c.Labels {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PoolConfig describes a client-side pool of memcached servers, i.e. the
+// servers among which clients distribute their keys.
+type PoolConfig struct {
+	Name      string             `yaml:"name"`
+	Hash      string             `yaml:"hash,omitempty"`
+	Aggregate bool               `yaml:"aggregate,omitempty"`
+	Servers   []PoolServerConfig `yaml:"servers"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *PoolConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = PoolConfig{Hash: HashKetama}
+	type plain PoolConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Name == "" {
+		return fmt.Errorf("pool is missing name")
+	}
+	if c.Hash != HashKetama && c.Hash != HashModula {
+		return fmt.Errorf("pool %q has unknown hash %q", c.Name, c.Hash)
+	}
+	if len(c.Servers) == 0 {
+		return fmt.Errorf("pool %q has no servers", c.Name)
+	}
+	return nil
+}
+
+// PoolServerConfig is a server of a client pool.
+type PoolServerConfig struct {
+	Address string  `yaml:"address"`
+	Weight  float64 `yaml:"weight,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *PoolServerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = PoolServerConfig{Weight: 1}
+	type plain PoolServerConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Address == "" {
+		return fmt.Errorf("pool server is missing address")
+	}
+	if c.Weight <= 0 {
+		return fmt.Errorf("pool server %q must have a positive weight", c.Address)
+	}
+	return nil
+}
+
+// ModuleConfig is a named set of options that a scrape of the multi-target
+// endpoint can select with the module parameter.
+type ModuleConfig struct {
+	Slabs *SlabsConfig `yaml:"slabs,omitempty"`
+}
+
+// SlabsConfig reduces the number of per-slab-class series of a server.
+type SlabsConfig struct {
+	// Drop lists the names of per-slab-class metrics that are not exported.
+	Drop []string `yaml:"drop,omitempty"`
+	// MaxClasses is the maximum number of slab classes exported, keeping the
+	// classes that store the most items. Zero means no limit.
+	MaxClasses int `yaml:"max_classes,omitempty"`
+	// SizeBuckets are increasing upper bounds of chunk sizes in bytes. If set,
+	// slab classes are aggregated into these buckets, plus a bucket for larger
+	// chunks, and a size_bucket label replaces the slab label.
+	SizeBuckets []uint64 `yaml:"size_buckets,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *SlabsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain SlabsConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	for _, name := range c.Drop {
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			return fmt.Errorf("invalid metric name %q", name)
+		}
+	}
+	if c.MaxClasses < 0 {
+		return fmt.Errorf("max_classes must not be negative")
+	}
+	for i, bound := range c.SizeBuckets {
+		if bound == 0 || (i > 0 && bound <= c.SizeBuckets[i-1]) {
+			return fmt.Errorf("size_buckets must be positive and increasing")
+		}
+	}
+	return nil
+}
+
+// RemoteWriteConfig configures the sending of the metrics to a Prometheus
+// remote-write endpoint.
+type RemoteWriteConfig struct {
+	URL string `yaml:"url"`
+	// Interval is the interval between two collections of the metrics.
+	Interval model.Duration `yaml:"interval,omitempty"`
+	// Targets are collected in addition to the servers given with
+	// --memcached.address.
+	Targets        []string          `yaml:"targets,omitempty"`
+	ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
+	// MaxQueuedRequests is the maximum number of requests waiting to be
+	// sent, beyond which the oldest requests are dropped.
+	MaxQueuedRequests int `yaml:"max_queued_requests,omitempty"`
+	// MinBackoff and MaxBackoff bound the time between two attempts to send
+	// a request that failed with a recoverable error.
+	MinBackoff model.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff model.Duration `yaml:"max_backoff,omitempty"`
+
+	HTTPClientConfig promconfig.HTTPClientConfig `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = RemoteWriteConfig{
+		Interval:          model.Duration(30 * time.Second),
+		MaxQueuedRequests: 100,
+		MinBackoff:        model.Duration(time.Second),
+		MaxBackoff:        model.Duration(time.Minute),
+		HTTPClientConfig:  promconfig.DefaultHTTPClientConfig,
+	}
+	type plain RemoteWriteConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.URL == "" {
+		return fmt.Errorf("remote_write is missing url")
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid remote_write url %q", c.URL)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("remote_write interval must be positive")
+	}
+	if c.MaxQueuedRequests <= 0 {
+		return fmt.Errorf("remote_write max_queued_requests must be positive")
+	}
+	if c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff {
+		return fmt.Errorf("remote_write backoff must be positive, with max_backoff not less than min_backoff")
+	}
+	for name := range c.ExternalLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid external label name %q", name)
+		}
+	}
+	return c.HTTPClientConfig.Validate()
+}
+
+// GraphiteConfig configures the pushing of the metrics to Graphite.
+type GraphiteConfig struct {
+	// Address is the host:port of the plaintext listener of Graphite.
+	Address  string         `yaml:"address"`
+	Interval model.Duration `yaml:"interval,omitempty"`
+	Timeout  model.Duration `yaml:"timeout,omitempty"`
+	// Templates build the path of a sample from its labels, e.g.
+	// memcached.{server}.{metric}. The first template whose placeholders
+	// are all labels of the sample applies.
+	Templates []string `yaml:"templates,omitempty"`
+	// LabelOrder orders the values of the labels not in the template, which
+	// are appended to the path.
+	LabelOrder []string `yaml:"label_order,omitempty"`
+	// MaxBufferedLines is the maximum number of lines kept while Graphite
+	// is unreachable.
+	MaxBufferedLines int `yaml:"max_buffered_lines,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *GraphiteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = GraphiteConfig{
+		Interval:         model.Duration(time.Minute),
+		Timeout:          model.Duration(10 * time.Second),
+		Templates:        []string{"memcached.{server}.{metric}"},
+		MaxBufferedLines: 100000,
+	}
+	type plain GraphiteConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid graphite address %q: %w", c.Address, err)
+	}
+	if c.Interval <= 0 || c.Timeout <= 0 {
+		return fmt.Errorf("graphite interval and timeout must be positive")
+	}
+	if len(c.Templates) == 0 {
+		return fmt.Errorf("graphite has no templates")
+	}
+	if c.MaxBufferedLines <= 0 {
+		return fmt.Errorf("graphite max_buffered_lines must be positive")
+	}
+	return nil
+}
+
+// ScrapeConfig configures the multi-target endpoint.
+type ScrapeConfig struct {
+	// Allow restricts the targets that may be scraped. All targets are
+	// allowed if it is nil.
+	Allow *AllowConfig `yaml:"allow,omitempty"`
+}
+
+// AllowConfig is an allowlist of targets. A TCP target is allowed if its port
+// is in one of Ports, its hostname matches one of Hostnames, and all of its
+// addresses are in one of CIDRs, where empty lists do not restrict the
+// targets. IP addresses are only checked against CIDRs, and are rejected if
+// there are none. A unix socket is allowed if it is located in one of
+// UnixSocketPrefixes.
+type AllowConfig struct {
+	CIDRs              []CIDR      `yaml:"cidrs,omitempty"`
+	Hostnames          []Regexp    `yaml:"hostnames,omitempty"`
+	Ports              []PortRange `yaml:"ports,omitempty"`
+	UnixSocketPrefixes []string    `yaml:"unix_socket_prefixes,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *AllowConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain AllowConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	for _, prefix := range c.UnixSocketPrefixes {
+		if !filepath.IsAbs(prefix) {
+			return fmt.Errorf("unix socket prefix %q is not an absolute path", prefix)
+		}
+	}
+	return nil
+}
+
+// CIDR is an IP network in CIDR notation, e.g. 10.0.0.0/8.
+type CIDR struct {
+	*net.IPNet
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *CIDR) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return err
+	}
+	c.IPNet = ipNet
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (c CIDR) MarshalYAML() (interface{}, error) {
+	if c.IPNet != nil {
+		return c.String(), nil
+	}
+	return nil, nil
+}
+
+// PortRange is an inclusive range of ports, written as a single port, e.g.
+// 11211, or as a range, e.g. 11211-11219.
+type PortRange struct {
+	Min, Max uint16
+}
+
+// Contains reports whether port is in the range.
+func (r PortRange) Contains(port uint16) bool {
+	return r.Min <= port && port <= r.Max
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (r *PortRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	min, max, isRange := strings.Cut(s, "-")
+	if !isRange {
+		max = min
+	}
+	lo, err := strconv.ParseUint(strings.TrimSpace(min), 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port range %q", s)
+	}
+	hi, err := strconv.ParseUint(strings.TrimSpace(max), 10, 16)
+	if err != nil || hi < lo {
+		return fmt.Errorf("invalid port range %q", s)
+	}
+	*r = PortRange{Min: uint16(lo), Max: uint16(hi)}
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (r PortRange) MarshalYAML() (interface{}, error) {
+	if r.Min == r.Max {
+		return strconv.Itoa(int(r.Min)), nil
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max), nil
+}
+
+// Regexp encapsulates a regexp.Regexp and makes it YAML marshalable. The
+// expression is anchored at both ends.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp creates a new anchored Regexp.
+func NewRegexp(s string) (Regexp, error) {
+	re, err := regexp.Compile("^(?:" + s + ")$")
+	return Regexp{Regexp: re, original: s}, err
+}
+
+// MustNewRegexp works like NewRegexp, but panics if the expression is invalid.
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return err
+	}
+	*re = r
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	if re.Regexp != nil {
+		return re.original, nil
+	}
+	return nil, nil
+}
+
+// String returns the original string used to compile the regular expression.
+func (re Regexp) String() string {
+	return re.original
+}
+
+// Load parses the YAML configuration file at filename.
+func Load(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	if cfg.RemoteWrite != nil {
+		cfg.RemoteWrite.HTTPClientConfig.SetDirectory(filepath.Dir(filename))
+	}
+	return cfg, nil
+}
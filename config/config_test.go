@@ -0,0 +1,195 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func loadString(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(filename)
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		t.Parallel()
+		cfg, err := loadString(t, `
+targets:
+  - match: /run/memcached/(?P<tenant>[a-z]+)-(?P<shard>\d+)\.sock
+    alias: $tenant-$shard
+    labels:
+      env: prod
+    slabs:
+      drop: [memcached_slab_commands_total]
+    pid_file: /run/memcached/$tenant-$shard.pid
+pools:
+  - name: sessions
+    servers:
+      - address: 10.0.0.1:11211
+      - address: 10.0.0.2:11211
+        weight: 2
+scrape:
+  allow:
+    cidrs: [10.0.0.0/8]
+    hostnames: ['memcached-\d+\.internal']
+    ports: [11211, 21000-21099]
+    unix_socket_prefixes: [/run/memcached]
+modules:
+  small:
+    slabs:
+      max_classes: 5
+      size_buckets: [128, 1024]
+remote_write:
+  url: https://prometheus.example.com/api/v1/write
+  targets: [memcached-1:11211]
+  external_labels:
+    site: edge-1
+  basic_auth:
+    username: edge
+    password_file: password
+graphite:
+  address: graphite:2003
+  templates: ['memcached.{server}.slab.{slab}.{metric}', 'memcached.{server}.{metric}']
+  label_order: [status, command]
+`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cfg.Targets) != 1 {
+			t.Fatalf("expected 1 target, got %d", len(cfg.Targets))
+		}
+		target := cfg.Targets[0]
+		if !target.Match.MatchString("/run/memcached/billing-3.sock") {
+			t.Error("expected match to match socket path")
+		}
+		if target.Match.MatchString("/run/memcached/billing-3.sock.old") {
+			t.Error("expected match to be anchored")
+		}
+		if names, want := target.LabelNames(), []string{"env", "shard", "tenant"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("want label names %v, have %v", want, names)
+		}
+		if want := (&SlabsConfig{Drop: []string{"memcached_slab_commands_total"}}); !reflect.DeepEqual(target.Slabs, want) {
+			t.Errorf("want slabs %+v, have %+v", want, target.Slabs)
+		}
+		if want := "/run/memcached/$tenant-$shard.pid"; target.PIDFile != want {
+			t.Errorf("want pid file %q, have %q", want, target.PIDFile)
+		}
+		want := []PoolConfig{{
+			Name: "sessions",
+			Hash: HashKetama,
+			Servers: []PoolServerConfig{
+				{Address: "10.0.0.1:11211", Weight: 1},
+				{Address: "10.0.0.2:11211", Weight: 2},
+			},
+		}}
+		if !reflect.DeepEqual(cfg.Pools, want) {
+			t.Errorf("want pools %+v, have %+v", want, cfg.Pools)
+		}
+
+		allow := cfg.Scrape.Allow
+		if allow == nil {
+			t.Fatal("expected scrape allowlist")
+		}
+		if len(allow.CIDRs) != 1 || allow.CIDRs[0].String() != "10.0.0.0/8" {
+			t.Errorf("want cidrs [10.0.0.0/8], have %v", allow.CIDRs)
+		}
+		if len(allow.Hostnames) != 1 || !allow.Hostnames[0].MatchString("memcached-1.internal") {
+			t.Errorf("expected hostname to match, have %v", allow.Hostnames)
+		}
+		if want := []PortRange{{11211, 11211}, {21000, 21099}}; !reflect.DeepEqual(allow.Ports, want) {
+			t.Errorf("want ports %v, have %v", want, allow.Ports)
+		}
+		if want := []string{"/run/memcached"}; !reflect.DeepEqual(allow.UnixSocketPrefixes, want) {
+			t.Errorf("want unix socket prefixes %v, have %v", want, allow.UnixSocketPrefixes)
+		}
+
+		wantModules := map[string]ModuleConfig{
+			"small": {Slabs: &SlabsConfig{MaxClasses: 5, SizeBuckets: []uint64{128, 1024}}},
+		}
+		if !reflect.DeepEqual(cfg.Modules, wantModules) {
+			t.Errorf("want modules %+v, have %+v", wantModules, cfg.Modules)
+		}
+
+		wantGraphite := &GraphiteConfig{
+			Address:          "graphite:2003",
+			Interval:         model.Duration(time.Minute),
+			Timeout:          model.Duration(10 * time.Second),
+			Templates:        []string{"memcached.{server}.slab.{slab}.{metric}", "memcached.{server}.{metric}"},
+			LabelOrder:       []string{"status", "command"},
+			MaxBufferedLines: 100000,
+		}
+		if !reflect.DeepEqual(cfg.Graphite, wantGraphite) {
+			t.Errorf("want graphite %+v, have %+v", wantGraphite, cfg.Graphite)
+		}
+
+		rw := cfg.RemoteWrite
+		if rw == nil {
+			t.Fatal("expected remote write")
+		}
+		if rw.Interval != model.Duration(30*time.Second) || rw.MaxQueuedRequests != 100 {
+			t.Errorf("want default interval and queue, have %v and %d", rw.Interval, rw.MaxQueuedRequests)
+		}
+		if want := map[string]string{"site": "edge-1"}; !reflect.DeepEqual(rw.ExternalLabels, want) {
+			t.Errorf("want external labels %v, have %v", want, rw.ExternalLabels)
+		}
+		if file := rw.HTTPClientConfig.BasicAuth.PasswordFile; !filepath.IsAbs(file) || filepath.Base(file) != "password" {
+			t.Errorf("want password file relative to the config file, have %q", file)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		t.Parallel()
+		for _, content := range []string{
+			"targets:\n  - alias: foo\n",
+			"targets:\n  - match: '('\n",
+			"targets:\n  - match: (?P<server>.*)\n",
+			"targets:\n  - match: .*\n    labels:\n      0invalid: foo\n",
+			"unknown: true\n",
+			"pools:\n  - servers: [{address: a:11211}]\n",
+			"pools:\n  - name: a\n    hash: crc32\n    servers: [{address: a:11211}]\n",
+			"pools:\n  - name: a\n    servers: [{address: a:11211, weight: -1}]\n",
+			"pools:\n  - name: a\n    servers: [{address: a:11211}]\n  - name: a\n    servers: [{address: b:11211}]\n",
+			"scrape:\n  allow:\n    cidrs: [10.0.0.1]\n",
+			"scrape:\n  allow:\n    ports: [11219-11211]\n",
+			"scrape:\n  allow:\n    ports: [70000]\n",
+			"scrape:\n  allow:\n    unix_socket_prefixes: [run/memcached]\n",
+			"targets:\n  - match: .*\n    slabs:\n      drop: [0invalid]\n",
+			"modules:\n  a:\n    slabs:\n      max_classes: -1\n",
+			"modules:\n  a:\n    slabs:\n      size_buckets: [1024, 128]\n",
+			"modules:\n  a:\n    slabs:\n      size_buckets: [0]\n",
+			"remote_write:\n  targets: [a:11211]\n",
+			"graphite:\n  address: graphite\n",
+			"graphite:\n  address: graphite:2003\n  templates: []\n",
+			"remote_write:\n  url: ftp://example.com\n",
+			"remote_write:\n  url: http://example.com\n  external_labels:\n    0invalid: a\n",
+			"remote_write:\n  url: http://example.com\n  min_backoff: 1m\n  max_backoff: 1s\n",
+			"remote_write:\n  url: http://example.com\n  bearer_token: a\n  basic_auth:\n    username: a\n",
+		} {
+			if _, err := loadString(t, content); err == nil {
+				t.Errorf("expected error loading %q", content)
+			}
+		}
+	})
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connpool
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	crlf                    = []byte("\r\n")
+	resultEnd               = []byte("END\r\n")
+	resultError             = []byte("ERROR\r\n")
+	resultStatPrefix        = []byte("STAT ")
+	resultVersionPrefix     = []byte("VERSION ")
+	resultClientErrorPrefix = []byte("CLIENT_ERROR ")
+	resultServerErrorPrefix = []byte("SERVER_ERROR ")
+)
+
+// ProtocolError is returned when a server answers with an error or a response
+// that does not follow the memcached protocol.
+type ProtocolError struct {
+	Command string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("memcache: %s: %s", e.Command, e.Message)
+}
+
+// Conn is a connection to a memcached server.
+type Conn struct {
+	server  string
+	nc      net.Conn
+	rw      *bufio.ReadWriter
+	timeout time.Duration
+
+	dialed   time.Time
+	lastUsed time.Time
+	reused   bool
+}
+
+func dial(server string, timeout time.Duration, tlsConfig *tls.Config, control func(network, address string, c syscall.RawConn) error) (*Conn, error) {
+	var (
+		nc  net.Conn
+		err error
+	)
+	dialer := net.Dialer{Timeout: timeout, Control: control}
+	if tlsConfig != nil {
+		nc, err = tls.DialWithDialer(&dialer, network(server), server, tlsConfig)
+	} else {
+		nc, err = dialer.Dial(network(server), server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		server:  server,
+		nc:      nc,
+		rw:      bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+		timeout: timeout,
+		dialed:  time.Now(),
+	}, nil
+}
+
+// Server returns the address the connection was obtained for.
+func (c *Conn) Server() string {
+	return c.server
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.nc.RemoteAddr()
+}
+
+// Reused reports whether the connection was used before.
+func (c *Conn) Reused() bool {
+	return c.reused
+}
+
+// Version returns the version of the server.
+func (c *Conn) Version() (string, error) {
+	line, err := c.command("version")
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(line, resultVersionPrefix) {
+		return "", &ProtocolError{Command: "version", Message: fmt.Sprintf("unexpected response %q", line)}
+	}
+	return string(bytes.TrimSuffix(line[len(resultVersionPrefix):], crlf)), nil
+}
+
+// Stats issues the stats command with the given arguments, e.g. "settings",
+// and returns the reported statistics.
+func (c *Conn) Stats(args string) (map[string]string, error) {
+	cmd := strings.TrimSpace("stats " + args)
+	line, err := c.command(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]string{}
+	for !bytes.Equal(line, resultEnd) {
+		if !bytes.HasPrefix(line, resultStatPrefix) {
+			return nil, &ProtocolError{Command: cmd, Message: fmt.Sprintf("unexpected stats line format %q", line)}
+		}
+		kv := bytes.SplitN(bytes.TrimSuffix(line[len(resultStatPrefix):], crlf), []byte(" "), 2)
+		if len(kv) != 2 {
+			return nil, &ProtocolError{Command: cmd, Message: fmt.Sprintf("unexpected stats line format %q", line)}
+		}
+		stats[string(kv[0])] = string(bytes.TrimSpace(kv[1]))
+
+		if line, err = c.rw.ReadSlice('\n'); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// command writes cmd and returns the first line of the response.
+func (c *Conn) command(cmd string) ([]byte, error) {
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err := c.nc.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := c.rw.WriteString(cmd + "\r\n"); err != nil {
+		return nil, err
+	}
+	if err := c.rw.Flush(); err != nil {
+		return nil, err
+	}
+	line, err := c.rw.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(line, resultError):
+		return nil, &ProtocolError{Command: cmd, Message: "unknown command"}
+	case bytes.HasPrefix(line, resultClientErrorPrefix):
+		return nil, &ProtocolError{Command: cmd, Message: "client error: " + string(bytes.TrimSpace(line[len(resultClientErrorPrefix):]))}
+	case bytes.HasPrefix(line, resultServerErrorPrefix):
+		return nil, &ProtocolError{Command: cmd, Message: "server error: " + string(bytes.TrimSpace(line[len(resultServerErrorPrefix):]))}
+	}
+	return line, nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package connpool keeps long-lived connections to memcached servers, so that
+// the exporter does not open new connections on every scrape.
+package connpool
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxIdlePerServer is the maximum number of idle connections kept per
+	// server.
+	maxIdlePerServer = 2
+	// maxDialTimes is the maximum number of dial times remembered per server.
+	maxDialTimes = 1024
+)
+
+// ErrClosed is returned when getting a connection from a closed pool.
+var ErrClosed = errors.New("connection pool closed")
+
+// Pool is a pool of connections to memcached servers, keyed by address. It is
+// safe for concurrent use.
+type Pool struct {
+	timeout     time.Duration
+	tlsConfig   *tls.Config
+	idleTimeout time.Duration
+	dialControl func(network, address string, c syscall.RawConn) error
+
+	mutex     sync.Mutex
+	idle      map[string][]*Conn
+	open      map[string]int
+	dialTimes map[string][]time.Time
+	closed    bool
+	done      chan struct{}
+}
+
+// Option configures a Pool.
+type Option func(*Pool)
+
+// WithDialControl sets a function called with the resolved address of every
+// connection before it is established, as net.Dialer.Control. Returning an
+// error aborts the connection.
+func WithDialControl(control func(network, address string, c syscall.RawConn) error) Option {
+	return func(p *Pool) {
+		p.dialControl = control
+	}
+}
+
+// New returns a pool dialing connections with the given timeout and TLS
+// configuration. Connections unused for longer than idleTimeout are closed. If
+// idleTimeout is zero, connections are closed as soon as they are put back.
+func New(timeout time.Duration, tlsConfig *tls.Config, idleTimeout time.Duration, opts ...Option) *Pool {
+	p := &Pool{
+		timeout:     timeout,
+		tlsConfig:   tlsConfig,
+		idleTimeout: idleTimeout,
+		idle:        map[string][]*Conn{},
+		open:        map[string]int{},
+		dialTimes:   map[string][]time.Time{},
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if idleTimeout > 0 {
+		go p.evictIdle()
+	}
+	return p
+}
+
+// Get returns a healthy idle connection to server, or dials a new one. The
+// connection must be returned with Put.
+func (p *Pool) Get(server string) (*Conn, error) {
+	for {
+		c, err := p.popIdle(server)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			break
+		}
+		// Idle connections may have been closed by the server in the
+		// meantime, check that they still work before handing them out.
+		if _, err := c.Version(); err == nil {
+			return c, nil
+		}
+		p.discard(c)
+	}
+
+	c, err := dial(server, p.timeout, p.tlsConfig, p.dialControl)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		c.nc.Close()
+		return nil, ErrClosed
+	}
+	p.open[server]++
+	times := append(p.dialTimes[server], c.dialed)
+	if len(times) > maxDialTimes {
+		times = times[len(times)-maxDialTimes:]
+	}
+	p.dialTimes[server] = times
+	return c, nil
+}
+
+// Put returns a connection obtained from Get to the pool. If err is not nil,
+// the connection is assumed to be broken and closed instead.
+func (p *Pool) Put(c *Conn, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil || p.closed || p.idleTimeout == 0 || len(p.idle[c.server]) >= maxIdlePerServer {
+		p.closeLocked(c)
+		return
+	}
+	c.lastUsed = time.Now()
+	p.idle[c.server] = append(p.idle[c.server], c)
+}
+
+// Open returns the number of connections to server the pool currently holds,
+// both idle and in use.
+func (p *Pool) Open(server string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.open[server]
+}
+
+// Dials returns the number of connections the pool dialed to server since the
+// given time. Only the most recent dials are remembered.
+func (p *Pool) Dials(server string, since time.Time) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	n := 0
+	for _, t := range p.dialTimes[server] {
+		if !t.Before(since) {
+			n++
+		}
+	}
+	return n
+}
+
+// Close closes all idle connections and stops the pool. Connections in use
+// are closed when they are put back.
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for server, conns := range p.idle {
+		for _, c := range conns {
+			p.closeLocked(c)
+		}
+		delete(p.idle, server)
+	}
+	return nil
+}
+
+func (p *Pool) popIdle(server string) (*Conn, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	conns := p.idle[server]
+	if len(conns) == 0 {
+		return nil, nil
+	}
+	c := conns[len(conns)-1]
+	p.idle[server] = conns[:len(conns)-1]
+	c.reused = true
+	return c, nil
+}
+
+func (p *Pool) discard(c *Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closeLocked(c)
+}
+
+func (p *Pool) closeLocked(c *Conn) {
+	c.nc.Close()
+	p.open[c.server]--
+	if p.open[c.server] <= 0 {
+		delete(p.open, c.server)
+	}
+}
+
+// evictIdle periodically closes connections that have been idle for longer
+// than the idle timeout.
+func (p *Pool) evictIdle() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.mutex.Lock()
+			for server, conns := range p.idle {
+				kept := conns[:0]
+				for _, c := range conns {
+					if now.Sub(c.lastUsed) > p.idleTimeout {
+						p.closeLocked(c)
+					} else {
+						kept = append(kept, c)
+					}
+				}
+				if len(kept) == 0 {
+					delete(p.idle, server)
+				} else {
+					p.idle[server] = kept
+				}
+			}
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// network returns the network of a memcached address, which is a unix socket
+// if it contains a slash.
+func network(server string) string {
+	if strings.Contains(server, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connpool
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestPool(t *testing.T) {
+	t.Run("Reuse", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, map[string]map[string]string{
+			"": {"pid": "1", "version": "1.6.21"},
+		})
+		p := New(time.Second, nil, time.Minute)
+		defer p.Close()
+
+		for i := 0; i < 3; i++ {
+			c, err := p.Get(server.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reused := c.Reused(); reused != (i > 0) {
+				t.Errorf("expected connection %d to be reused: %t", i, i > 0)
+			}
+			stats, err := c.Stats("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats["version"] != "1.6.21" {
+				t.Errorf("unexpected stats %v", stats)
+			}
+			p.Put(c, nil)
+		}
+		if n := server.Connections(); n != 1 {
+			t.Errorf("expected 1 connection, got %d", n)
+		}
+		if n := p.Open(server.Addr); n != 1 {
+			t.Errorf("expected 1 open connection, got %d", n)
+		}
+		if n := p.Dials(server.Addr, time.Now().Add(-time.Minute)); n != 1 {
+			t.Errorf("expected 1 dial, got %d", n)
+		}
+		if n := p.Dials(server.Addr, time.Now().Add(time.Minute)); n != 0 {
+			t.Errorf("expected no dial in the future, got %d", n)
+		}
+	})
+
+	t.Run("Broken connection", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, map[string]map[string]string{"": {}})
+		p := New(time.Second, nil, time.Minute)
+		defer p.Close()
+
+		c, err := p.Get(server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Put(c, errors.New("broken"))
+		if n := p.Open(server.Addr); n != 0 {
+			t.Errorf("expected broken connection to be closed, got %d open", n)
+		}
+	})
+
+	t.Run("Health check", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, map[string]map[string]string{"": {}})
+		p := New(time.Second, nil, time.Minute)
+		defer p.Close()
+
+		c, err := p.Get(server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Put(c, nil)
+		// Simulate the server closing the idle connection.
+		c.nc.Close()
+
+		c, err = p.Get(server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Reused() {
+			t.Error("expected unhealthy connection to be replaced")
+		}
+		p.Put(c, nil)
+		if n := p.Open(server.Addr); n != 1 {
+			t.Errorf("expected 1 open connection, got %d", n)
+		}
+	})
+
+	t.Run("Idle eviction", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, map[string]map[string]string{"": {}})
+		p := New(time.Second, nil, 20*time.Millisecond)
+		defer p.Close()
+
+		c, err := p.Get(server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Put(c, nil)
+		deadline := time.Now().Add(time.Second)
+		for p.Open(server.Addr) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("expected idle connection to be evicted")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, map[string]map[string]string{"": {}})
+		p := New(time.Second, nil, time.Minute)
+
+		c, err := p.Get(server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Put(c, nil)
+		p.Close()
+		if n := p.Open(server.Addr); n != 0 {
+			t.Errorf("expected all connections to be closed, got %d open", n)
+		}
+		if _, err := p.Get(server.Addr); err != ErrClosed {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("Dial control", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, map[string]map[string]string{"": {}})
+		errDenied := errors.New("denied")
+		var addresses []string
+		p := New(time.Second, nil, time.Minute, WithDialControl(func(network, address string, c syscall.RawConn) error {
+			addresses = append(addresses, address)
+			return errDenied
+		}))
+		defer p.Close()
+
+		if _, err := p.Get(server.Addr); !errors.Is(err, errDenied) {
+			t.Errorf("expected the dial to be denied, got %v", err)
+		}
+		if len(addresses) != 1 || addresses[0] != server.Addr {
+			t.Errorf("expected control to be called with %q, got %q", server.Addr, addresses)
+		}
+		if n := server.Connections(); n != 0 {
+			t.Errorf("expected no connection, got %d", n)
+		}
+	})
+}
+
+func TestConnStats(t *testing.T) {
+	server := memcachedtest.NewServer(t, map[string]map[string]string{
+		"settings": {"maxconns": "1024", "inter": "0.0.0.0 11211"},
+	})
+	p := New(time.Second, nil, 0)
+	defer p.Close()
+
+	c, err := p.Get(server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Put(c, nil)
+
+	settings, err := c.Stats("settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["inter"] != "0.0.0.0 11211" {
+		t.Errorf("expected multi-word value, got %q", settings["inter"])
+	}
+
+	var protocolError *ProtocolError
+	if _, err := c.Stats("unknown"); !errors.As(err, &protocolError) {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+}
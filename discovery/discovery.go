@@ -0,0 +1,193 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery finds the memcached servers running on the local host
+// from the proc filesystem.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/procfs"
+)
+
+// comm is the command name of the memcached processes.
+const comm = "memcached"
+
+// tcpListen is the state of a listening socket in net/tcp and net/tcp6.
+const tcpListen = 0x0A
+
+// unixListen is the flag of a listening socket in net/unix.
+const unixListen = 1 << 16
+
+// Instance is a local memcached process and the address it is collected on.
+type Instance struct {
+	PID     int
+	Address string
+}
+
+// listener is a listening socket.
+type listener struct {
+	address string
+	// rank orders the listeners of a process by preference.
+	rank int
+}
+
+// Scan returns the memcached processes of the proc filesystem mounted at
+// path that listen on a TCP or unix socket, sorted by pid.
+//
+// A process is collected on one address only, even if it listens on several,
+// so that its metrics are not duplicated. IPv4 is preferred over IPv6, and
+// TCP over unix sockets. A process listening on all interfaces is collected
+// on the loopback interface.
+func Scan(path string) ([]Instance, error) {
+	proc, err := procfs.NewFS(path)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := proc.AllProcs()
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, p := range procs {
+		// Processes may exit while they are scanned.
+		if name, err := p.Comm(); err == nil && name == comm {
+			pids = append(pids, p.PID)
+		}
+	}
+	if len(pids) == 0 {
+		return nil, nil
+	}
+
+	listeners, err := readListeners(proc)
+	if err != nil {
+		return nil, err
+	}
+	var instances []Instance
+	for _, pid := range pids {
+		p, err := proc.Proc(pid)
+		if err != nil {
+			continue
+		}
+		// The file descriptors are only readable by the owner of the process.
+		targets, err := p.FileDescriptorTargets()
+		if err != nil {
+			continue
+		}
+		var best *listener
+		for _, target := range targets {
+			inode, ok := socketInode(target)
+			if !ok {
+				continue
+			}
+			if l, ok := listeners[inode]; ok && (best == nil || l.rank < best.rank ||
+				l.rank == best.rank && l.address < best.address) {
+				best = &l
+			}
+		}
+		if best != nil {
+			instances = append(instances, Instance{PID: pid, Address: best.address})
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].PID < instances[j].PID })
+	return instances, nil
+}
+
+// readListeners returns the listening TCP and unix sockets, by inode.
+func readListeners(proc procfs.FS) (map[uint64]listener, error) {
+	listeners := map[uint64]listener{}
+	for rank, read := range []func() (procfs.NetTCP, error){proc.NetTCP, proc.NetTCP6} {
+		sockets, err := read()
+		if errors.Is(err, fs.ErrNotExist) {
+			// IPv6 may be disabled.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sockets {
+			if s.St != tcpListen {
+				continue
+			}
+			ip := s.LocalAddr
+			if ip.IsUnspecified() {
+				ip = net.IPv6loopback
+				if ip4 := s.LocalAddr.To4(); ip4 != nil {
+					ip = net.IPv4(127, 0, 0, 1)
+				}
+			}
+			address := net.JoinHostPort(ip.String(), strconv.FormatUint(s.LocalPort, 10))
+			listeners[s.Inode] = listener{address: address, rank: rank}
+		}
+	}
+
+	unix, err := proc.NetUNIX()
+	if errors.Is(err, fs.ErrNotExist) {
+		return listeners, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range unix.Rows {
+		// Abstract sockets start with "@" and cannot be dialed by path.
+		if s.Flags&unixListen != 0 && strings.HasPrefix(s.Path, "/") {
+			listeners[s.Inode] = listener{address: s.Path, rank: 2}
+		}
+	}
+	return listeners, nil
+}
+
+// socketInode returns the inode of the socket a file descriptor links to.
+func socketInode(target string) (uint64, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	return inode, err == nil
+}
+
+// Watch scans the proc filesystem mounted at path every interval, until ctx
+// is done, and calls update with the instances found whenever they differ
+// from the last ones, starting with last.
+func Watch(ctx context.Context, path string, interval time.Duration, logger log.Logger, last []Instance, update func([]Instance)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		instances, err := Scan(path)
+		if err != nil {
+			level.Error(logger).Log("msg", "Failed to discover local memcached servers", "err", err)
+			continue
+		}
+		if !reflect.DeepEqual(instances, last) {
+			level.Info(logger).Log("msg", "Discovered local memcached servers", "servers", len(instances))
+			update(instances)
+			last = instances
+		}
+	}
+}
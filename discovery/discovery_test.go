@@ -0,0 +1,120 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/tdewolff/memcached_exporter/internal/procfstest"
+)
+
+func TestScan(t *testing.T) {
+	fs := procfstest.New(t)
+	// Listening on all interfaces, over IPv4 and IPv6, with a client.
+	fs.AddTCPSocket(1, "0.0.0.0:11211", true)
+	fs.AddTCPSocket(2, "[::]:11211", true)
+	fs.AddTCPSocket(3, "127.0.0.1:11211", false)
+	fs.AddProcess(100, procfstest.Process{Comm: "memcached", FDs: 3, Sockets: []uint64{1, 2, 3}})
+	// Listening on a unix socket only.
+	fs.AddUnixSocket(4, "/run/memcached/memcached.sock", true)
+	fs.AddUnixSocket(5, "/run/memcached/memcached.sock", false)
+	fs.AddProcess(200, procfstest.Process{Comm: "memcached", Sockets: []uint64{4, 5}})
+	// Listening on an interface and a unix socket.
+	fs.AddTCPSocket(6, "10.0.0.1:11212", true)
+	fs.AddUnixSocket(7, "/run/memcached/other.sock", true)
+	fs.AddProcess(300, procfstest.Process{Comm: "memcached", Sockets: []uint64{7, 6}})
+	// Listening on IPv6 only.
+	fs.AddTCPSocket(8, "[::]:11213", true)
+	fs.AddProcess(400, procfstest.Process{Comm: "memcached", Sockets: []uint64{8}})
+	// Not memcached.
+	fs.AddTCPSocket(9, "0.0.0.0:80", true)
+	fs.AddProcess(500, procfstest.Process{Comm: "nginx", Sockets: []uint64{9}})
+	// Not listening.
+	fs.AddTCPSocket(10, "127.0.0.1:40000", false)
+	fs.AddProcess(600, procfstest.Process{Comm: "memcached", Sockets: []uint64{10}})
+
+	instances, err := Scan(fs.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Instance{
+		{PID: 100, Address: "127.0.0.1:11211"},
+		{PID: 200, Address: "/run/memcached/memcached.sock"},
+		{PID: 300, Address: "10.0.0.1:11212"},
+		{PID: 400, Address: "[::1]:11213"},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("want %v, got %v", want, instances)
+	}
+}
+
+func TestScanNoNetwork(t *testing.T) {
+	fs := procfstest.New(t)
+	fs.AddProcess(100, procfstest.Process{Comm: "memcached"})
+
+	instances, err := Scan(fs.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 0 {
+		t.Errorf("want no instances, got %v", instances)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fs := procfstest.New(t)
+	fs.AddTCPSocket(1, "127.0.0.1:11211", true)
+	fs.AddProcess(100, procfstest.Process{Comm: "memcached", Sockets: []uint64{1}})
+	last, err := Scan(fs.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []Instance, 10)
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, fs.Path, 10*time.Millisecond, log.NewNopLogger(), last, func(instances []Instance) {
+			updates <- instances
+		})
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Unchanged instances are not updated.
+	select {
+	case instances := <-updates:
+		t.Fatalf("unexpected update %v", instances)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fs.AddTCPSocket(2, "127.0.0.1:11212", true)
+	fs.AddProcess(200, procfstest.Process{Comm: "memcached", Sockets: []uint64{2}})
+	select {
+	case instances := <-updates:
+		want := []Instance{{PID: 100, Address: "127.0.0.1:11211"}, {PID: 200, Address: "127.0.0.1:11212"}}
+		if !reflect.DeepEqual(instances, want) {
+			t.Errorf("want %v, got %v", want, instances)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update")
+	}
+}
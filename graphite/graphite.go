@@ -0,0 +1,192 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package graphite pushes the metrics of a Prometheus gatherer to Graphite
+// using the plaintext protocol.
+package graphite
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pusher periodically converts the metrics of a gatherer to plaintext lines
+// and writes them to a Graphite server over TCP. Lines that could not be
+// written are buffered and written again once the connection is back.
+type Pusher struct {
+	address     string
+	gatherer    prometheus.Gatherer
+	converter   *Converter
+	logger      log.Logger
+	timeout     time.Duration
+	maxBuffered int
+	now         func() time.Time
+
+	conn   net.Conn
+	buffer []string
+	// offset is the number of bytes of the first buffered line, including
+	// its newline, that were already written.
+	offset  int
+	dropped prometheus.Counter
+}
+
+// Option configures a Pusher.
+type Option func(*Pusher)
+
+// WithTimeout sets the timeout of connecting and writing to Graphite. It
+// defaults to 10s.
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *Pusher) {
+		p.timeout = timeout
+	}
+}
+
+// WithMaxBuffered sets the maximum number of lines buffered while Graphite is
+// unreachable, beyond which the oldest lines are dropped. It defaults to
+// 100000.
+func WithMaxBuffered(n int) Option {
+	return func(p *Pusher) {
+		p.maxBuffered = n
+	}
+}
+
+// New returns a Pusher writing the metrics of gatherer, converted by
+// converter, to the Graphite server at address.
+func New(address string, gatherer prometheus.Gatherer, converter *Converter, logger log.Logger, opts ...Option) *Pusher {
+	p := &Pusher{
+		address:     address,
+		gatherer:    gatherer,
+		converter:   converter,
+		logger:      logger,
+		timeout:     10 * time.Second,
+		maxBuffered: 100000,
+		now:         time.Now,
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "memcached_exporter_graphite_dropped_lines_total",
+			Help: "Number of Graphite lines dropped because the buffer was full.",
+		}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Describe implements prometheus.Collector.
+func (p *Pusher) Describe(ch chan<- *prometheus.Desc) {
+	p.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (p *Pusher) Collect(ch chan<- prometheus.Metric) {
+	p.dropped.Collect(ch)
+}
+
+// Run pushes the metrics at every interval until ctx is done, and then closes
+// the connection.
+func (p *Pusher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Push(ctx); err != nil {
+			level.Error(p.logger).Log("msg", "Failed to push metrics to Graphite", "address", p.address, "buffered", len(p.buffer), "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			if p.conn != nil {
+				p.conn.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Push gathers the metrics and writes them, along with the lines buffered by
+// previous pushes, to Graphite. It is not safe for concurrent use.
+func (p *Pusher) Push(ctx context.Context) error {
+	mfs, gatherErr := p.gatherer.Gather()
+	p.buffer = append(p.buffer, p.converter.Lines(mfs, p.now())...)
+	if n := len(p.buffer) - p.maxBuffered; n > 0 {
+		p.dropped.Add(float64(n))
+		p.buffer = p.buffer[n:]
+		p.offset = 0
+	}
+
+	if err := p.write(ctx); err != nil {
+		return err
+	}
+	return gatherErr
+}
+
+// write writes the buffered lines, connecting to Graphite if needed. The
+// lines that were not completely written stay in the buffer.
+func (p *Pusher) write(ctx context.Context) error {
+	if len(p.buffer) == 0 {
+		return nil
+	}
+	// A write to a connection closed by Graphite usually succeeds, losing
+	// the lines, so check it first.
+	if p.conn != nil && !alive(p.conn) {
+		p.conn.Close()
+		p.conn = nil
+	}
+	if p.conn == nil {
+		dialer := net.Dialer{Timeout: p.timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", p.address)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	data := strings.Join(p.buffer, "\n") + "\n"
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	n, err := p.conn.Write([]byte(data[p.offset:]))
+	if err == nil {
+		p.buffer = p.buffer[:0]
+		p.offset = 0
+		return nil
+	}
+
+	// Keep the lines that were not completely written, with the offset of the
+	// rest of the first one, and reconnect on the next push.
+	p.conn.Close()
+	p.conn = nil
+	n += p.offset
+	written := 0
+	for written < len(p.buffer) && n >= len(p.buffer[written])+1 {
+		n -= len(p.buffer[written]) + 1
+		written++
+	}
+	p.buffer = p.buffer[written:]
+	p.offset = n
+	return err
+}
+
+// alive reports whether conn was not closed by the peer. Graphite never sends
+// anything, so reading from an open connection times out.
+func alive(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	var netErr net.Error
+	return err == nil || (errors.As(err, &netErr) && netErr.Timeout())
+}
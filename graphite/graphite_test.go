@@ -0,0 +1,212 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// listen starts a Graphite server on address, sending the received lines and
+// connections to the returned channels.
+func listen(t *testing.T, address string) (net.Listener, <-chan string, <-chan net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	lines := make(chan string, 100)
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+			go func() {
+				s := bufio.NewScanner(c)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+	return l, lines, conns
+}
+
+func expectLine(t *testing.T, lines <-chan string, want string) {
+	t.Helper()
+	select {
+	case line := <-lines:
+		if line != want {
+			t.Errorf("want line %q, got %q", want, line)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected line %q", want)
+	}
+}
+
+func testPusher(t *testing.T, address string) (*Pusher, prometheus.Gauge) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "memcached_up", Help: "Up."})
+	registry.MustRegister(up)
+	c, err := NewConverter([]string{"memcached.{metric}"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(address, registry, c, log.NewNopLogger(), WithTimeout(time.Second))
+	p.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return p, up
+}
+
+// shortConn accepts n bytes and then fails, keeping the written bytes.
+type shortConn struct {
+	net.Conn
+	n       int
+	written []byte
+}
+
+func (c *shortConn) Write(b []byte) (int, error) {
+	if len(b) > c.n {
+		c.written = append(c.written, b[:c.n]...)
+		return c.n, errors.New("short write")
+	}
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+func (c *shortConn) Read(b []byte) (int, error)       { return 0, os.ErrDeadlineExceeded }
+func (c *shortConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *shortConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *shortConn) Close() error                     { return nil }
+
+func TestPusher(t *testing.T) {
+	t.Run("Reconnect", func(t *testing.T) {
+		t.Parallel()
+		l, lines, conns := listen(t, "127.0.0.1:0")
+		p, up := testPusher(t, l.Addr().String())
+
+		up.Set(1)
+		if err := p.Push(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		expectLine(t, lines, "memcached.up 1 1700000000")
+
+		// Graphite closing the connection is detected before the next push.
+		(<-conns).Close()
+		time.Sleep(10 * time.Millisecond)
+		up.Set(0)
+		if err := p.Push(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		expectLine(t, lines, "memcached.up 0 1700000000")
+	})
+
+	t.Run("Buffer", func(t *testing.T) {
+		t.Parallel()
+		l, _, _ := listen(t, "127.0.0.1:0")
+		address := l.Addr().String()
+		l.Close()
+		p, up := testPusher(t, address)
+
+		up.Set(1)
+		if err := p.Push(context.Background()); err == nil {
+			t.Fatal("expected error pushing to a closed port")
+		}
+		if n := len(p.buffer); n != 1 {
+			t.Errorf("want 1 buffered line, got %d", n)
+		}
+
+		_, lines, _ := listen(t, address)
+		up.Set(0)
+		if err := p.Push(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		expectLine(t, lines, "memcached.up 1 1700000000")
+		expectLine(t, lines, "memcached.up 0 1700000000")
+	})
+
+	t.Run("Short write", func(t *testing.T) {
+		t.Parallel()
+		const stream = "memcached.up 1 1700000000\nmemcached.up 0 1700000000\n"
+		// A write may stop before the first line, within it, or right before
+		// its newline.
+		for _, n := range []int{0, 24, 25} {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			p, up := testPusher(t, l.Addr().String())
+			conn := &shortConn{n: n}
+			p.conn = conn
+
+			up.Set(1)
+			if err := p.Push(context.Background()); err == nil {
+				t.Fatal("expected error from short write")
+			}
+			up.Set(0)
+			if err := p.Push(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			// The second connection resumes where the first one stopped.
+			rest := make([]byte, len(stream)-n)
+			c, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(c, rest); err != nil {
+				t.Fatalf("short write of %d bytes: %v", n, err)
+			}
+			if got := string(conn.written) + string(rest); got != stream {
+				t.Errorf("short write of %d bytes: want %q, got %q", n, stream, got)
+			}
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		t.Parallel()
+		l, _, _ := listen(t, "127.0.0.1:0")
+		address := l.Addr().String()
+		l.Close()
+		p, _ := testPusher(t, address)
+		p.maxBuffered = 2
+
+		for i := 0; i < 3; i++ {
+			p.Push(context.Background())
+		}
+		if n := len(p.buffer); n != 2 {
+			t.Errorf("want 2 buffered lines, got %d", n)
+		}
+		if n := testutil.ToFloat64(p.dropped); n != 1 {
+			t.Errorf("want 1 dropped line, got %v", n)
+		}
+	})
+}
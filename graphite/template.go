@@ -0,0 +1,207 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// MetricPlaceholder is replaced by the metric name in templates.
+const MetricPlaceholder = "metric"
+
+// namespacePrefix is removed from the metric names.
+const namespacePrefix = "memcached_"
+
+var (
+	placeholderRE = regexp.MustCompile(`\{([^{}]*)\}`)
+	invalidRE     = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+)
+
+// template is a parsed path template, e.g. memcached.{server}.{metric}.
+type template struct {
+	segments []string
+	labels   []string
+}
+
+// parseTemplate parses a path template whose placeholders are label names or
+// {metric}, which it must contain.
+func parseTemplate(s string) (template, error) {
+	var t template
+	hasMetric := false
+	for _, segment := range strings.Split(s, ".") {
+		if segment == "" {
+			return t, fmt.Errorf("template %q has an empty path component", s)
+		}
+		for _, match := range placeholderRE.FindAllStringSubmatch(segment, -1) {
+			name := match[1]
+			if name == MetricPlaceholder {
+				hasMetric = true
+			} else if !model.LabelName(name).IsValid() {
+				return t, fmt.Errorf("template %q has invalid placeholder %q", s, match[0])
+			} else {
+				t.labels = append(t.labels, name)
+			}
+		}
+		t.segments = append(t.segments, segment)
+	}
+	if !hasMetric {
+		return t, fmt.Errorf("template %q is missing {%s}", s, MetricPlaceholder)
+	}
+	return t, nil
+}
+
+// matches reports whether all placeholders of t are labels of the sample.
+func (t template) matches(labels map[string]string) bool {
+	for _, name := range t.labels {
+		if labels[name] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// path expands t for a sample, appending the values of the labels that are
+// not in t in the order of labelOrder, followed by the others sorted by name.
+func (t template) path(metric string, labels map[string]string, labelOrder []string) string {
+	used := map[string]bool{}
+	for _, name := range t.labels {
+		used[name] = true
+	}
+	parts := make([]string, 0, len(t.segments)+len(labels))
+	for _, segment := range t.segments {
+		parts = append(parts, placeholderRE.ReplaceAllStringFunc(segment, func(placeholder string) string {
+			name := placeholder[1 : len(placeholder)-1]
+			if name == MetricPlaceholder {
+				return sanitize(metric)
+			}
+			return sanitize(labels[name])
+		}))
+	}
+
+	var rest []string
+	for name := range labels {
+		if !used[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		oi, oj := index(labelOrder, rest[i]), index(labelOrder, rest[j])
+		if oi != oj {
+			return oi < oj
+		}
+		return rest[i] < rest[j]
+	})
+	for _, name := range rest {
+		if labels[name] != "" {
+			parts = append(parts, sanitize(labels[name]))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// index returns the position of name in order, or len(order) if it is not
+// in it.
+func index(order []string, name string) int {
+	for i, n := range order {
+		if n == name {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// sanitize makes s usable as a path component by replacing every run of
+// characters other than letters, digits, underscores and dashes, such as the
+// slashes of socket paths or the colon before a port, by an underscore.
+func sanitize(s string) string {
+	return strings.Trim(invalidRE.ReplaceAllString(s, "_"), "_")
+}
+
+// Converter converts metric families to Graphite plaintext lines.
+type Converter struct {
+	templates  []template
+	labelOrder []string
+}
+
+// NewConverter returns a Converter building the paths of the samples from the
+// first of templates whose placeholders are all labels of the sample. The
+// values of the other labels are appended to the path, ordered as in
+// labelOrder and then by name.
+func NewConverter(templates, labelOrder []string) (*Converter, error) {
+	c := &Converter{labelOrder: labelOrder}
+	for _, s := range templates {
+		t, err := parseTemplate(s)
+		if err != nil {
+			return nil, err
+		}
+		c.templates = append(c.templates, t)
+	}
+	return c, nil
+}
+
+// Lines converts mfs to lines, using the timestamp of a sample if set or else
+// now. Samples that match no template are skipped.
+func (c *Converter) Lines(mfs []*dto.MetricFamily, now time.Time) []string {
+	var lines []string
+	for _, mf := range mfs {
+		name := strings.TrimPrefix(mf.GetName(), namespacePrefix)
+		for _, m := range mf.Metric {
+			t := now.Unix()
+			if m.TimestampMs != nil {
+				t = m.GetTimestampMs() / 1000
+			}
+			labels := make(map[string]string, len(m.Label))
+			for _, lp := range m.Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			add := func(metric string, value float64) {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					return
+				}
+				for _, tmpl := range c.templates {
+					if tmpl.matches(labels) {
+						path := tmpl.path(metric, labels, c.labelOrder)
+						lines = append(lines, path+" "+strconv.FormatFloat(value, 'f', -1, 64)+" "+strconv.FormatInt(t, 10))
+						return
+					}
+				}
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				add(name+"_sum", m.GetHistogram().GetSampleSum())
+				add(name+"_count", float64(m.GetHistogram().GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				add(name+"_sum", m.GetSummary().GetSampleSum())
+				add(name+"_count", float64(m.GetSummary().GetSampleCount()))
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphite
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConverter(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		t.Parallel()
+		registry := prometheus.NewRegistry()
+		items := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "memcached_slab_current_items", Help: "Items."}, []string{"server", "slab"})
+		items.WithLabelValues("/run/memcached/billing.sock", "1").Set(3)
+		commands := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "memcached_commands_total", Help: "Commands."}, []string{"server", "command", "status"})
+		commands.WithLabelValues("10.0.0.1:11211", "get", "hit").Add(5)
+		up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "memcached_up", Help: "Up."}, []string{"server"})
+		up.WithLabelValues("10.0.0.1:11211").Set(1)
+		registry.MustRegister(items, commands, up)
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := NewConverter([]string{
+			"memcached.{server}.slab.{slab}.{metric}",
+			"memcached.{server}.{metric}",
+		}, []string{"status", "command"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"memcached.10_0_0_1_11211.commands_total.hit.get 5 1700000000",
+			"memcached.run_memcached_billing_sock.slab.1.slab_current_items 3 1700000000",
+			"memcached.10_0_0_1_11211.up 1 1700000000",
+		}
+		if got := c.Lines(mfs, time.Unix(1700000000, 0)); !reflect.DeepEqual(got, want) {
+			t.Errorf("want lines %q, got %q", want, got)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		t.Parallel()
+		for _, template := range []string{
+			"memcached.{server}",
+			"memcached..{metric}",
+			"memcached.{0invalid}.{metric}",
+		} {
+			if _, err := NewConverter([]string{template}, nil); err == nil {
+				t.Errorf("expected error parsing template %q", template)
+			}
+		}
+	})
+}
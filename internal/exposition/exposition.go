@@ -0,0 +1,67 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exposition serves gathered metrics over HTTP, optionally with the
+// created timestamps as OpenMetrics _created samples.
+package exposition
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+// HandlerFor is like promhttp.HandlerFor with OpenMetrics enabled. If
+// createdSamples is true, clients that negotiate OpenMetrics get the created
+// timestamps of counters, summaries and histograms as _created samples.
+func HandlerFor(g prometheus.Gatherer, opts promhttp.HandlerOpts, createdSamples bool) http.Handler {
+	opts.EnableOpenMetrics = true
+	handler := promhttp.HandlerFor(g, opts)
+	if !createdSamples {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if format.FormatType() != expfmt.TypeOpenMetrics {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		mfs, err := g.Gather()
+		if err != nil {
+			if opts.ErrorLog != nil {
+				opts.ErrorLog.Println("error gathering metrics:", err)
+			}
+			if opts.ErrorHandling != promhttp.ContinueOnError {
+				http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format, expfmt.WithCreatedLines())
+		for _, mf := range mfs {
+			if err := enc.Encode(mf); err != nil {
+				if opts.ErrorLog != nil {
+					opts.ErrorLog.Println("error encoding and sending metric family:", err)
+				}
+				return
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			closer.Close()
+		}
+	})
+}
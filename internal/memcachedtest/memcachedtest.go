@@ -0,0 +1,182 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memcachedtest provides a fake memcached server answering stats
+// commands, for use in tests.
+package memcachedtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Server is a fake memcached server listening on a local TCP port.
+type Server struct {
+	Addr string
+
+	listener net.Listener
+
+	mutex       sync.Mutex
+	stats       map[string]map[string]string
+	connections int
+	commands    []string
+	hold        chan struct{}
+}
+
+// Stats returns a minimal set of stats the exporter can parse, keyed by the
+// arguments of the stats command as expected by NewServer.
+func Stats() map[string]map[string]string {
+	return map[string]map[string]string{
+		"": {
+			"uptime":            "3600",
+			"curr_connections":  "10",
+			"total_connections": "100",
+			"cmd_get":           "5",
+			"cmd_set":           "2",
+			"cas_misses":        "0",
+			"cas_hits":          "0",
+			"cas_badval":        "0",
+		},
+		"slabs": {
+			"1:chunk_size":   "96",
+			"1:cmd_set":      "2",
+			"1:cas_hits":     "0",
+			"1:cas_badval":   "0",
+			"active_slabs":   "1",
+			"total_malloced": "1048576",
+		},
+		"items":    {"items:1:number": "3"},
+		"settings": {"maxconns": "1024"},
+	}
+}
+
+// NewServer starts a fake server answering "stats <args>" with stats[args],
+// e.g. stats["settings"] for "stats settings". It is closed at the end of the
+// test.
+func NewServer(t testing.TB, stats map[string]map[string]string) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Addr: l.Addr().String(), listener: l, stats: stats}
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetStats replaces the answer to "stats <args>".
+func (s *Server) SetStats(args string, stats map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats[args] = stats
+}
+
+// Hold delays the answers to stats commands until the returned function is
+// called.
+func (s *Server) Hold() (release func()) {
+	hold := make(chan struct{})
+	s.mutex.Lock()
+	s.hold = hold
+	s.mutex.Unlock()
+	return func() {
+		s.mutex.Lock()
+		s.hold = nil
+		s.mutex.Unlock()
+		close(hold)
+	}
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *Server) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connections
+}
+
+// Commands returns the commands received so far.
+func (s *Server) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.listener.Close()
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.connections++
+		s.mutex.Unlock()
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		s.mutex.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mutex.Unlock()
+
+		var resp strings.Builder
+		switch {
+		case cmd == "version":
+			resp.WriteString("VERSION 1.6.21\r\n")
+		case cmd == "stats" || strings.HasPrefix(cmd, "stats "):
+			s.mutex.Lock()
+			stats, ok := s.stats[strings.TrimSpace(strings.TrimPrefix(cmd, "stats"))]
+			hold := s.hold
+			s.mutex.Unlock()
+			if hold != nil {
+				<-hold
+			}
+			if !ok {
+				resp.WriteString("ERROR\r\n")
+				break
+			}
+			keys := make([]string, 0, len(stats))
+			for k := range stats {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(&resp, "STAT %s %s\r\n", k, stats[k])
+			}
+			resp.WriteString("END\r\n")
+		default:
+			resp.WriteString("ERROR\r\n")
+		}
+		if _, err := c.Write([]byte(resp.String())); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package procfstest provides a fake proc filesystem in a temporary
+// directory, for use in tests.
+package procfstest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// BootTime is the boot time of the fake system, in seconds since the epoch.
+const BootTime = 1700000000
+
+// Process describes a process of the fake proc filesystem.
+type Process struct {
+	Comm string
+	// UTime and STime are the user and system CPU time in clock ticks of
+	// 1/100 second.
+	UTime, STime uint64
+	// StartTime is the time the process started after boot, in clock ticks.
+	StartTime uint64
+	// VSize is the virtual memory size in bytes, RSS the resident memory in
+	// pages.
+	VSize, RSS uint64
+	Threads    int
+	FDs        int
+	MaxFDs     uint64
+	// VoluntaryCtxtSwitches and NonVoluntaryCtxtSwitches are the numbers of
+	// context switches.
+	VoluntaryCtxtSwitches, NonVoluntaryCtxtSwitches uint64
+	// Sockets are the inodes of the sockets the process has open, in
+	// addition to its FDs.
+	Sockets []uint64
+	// Cgroup is the content of the cgroup file, e.g. "0::/memcached.service\n".
+	Cgroup string
+}
+
+// FS is a fake proc filesystem.
+type FS struct {
+	t testing.TB
+	// Path is the mount point of the filesystem.
+	Path string
+
+	tcp, tcp6, unix []string
+}
+
+// New creates an empty fake proc filesystem, which is removed at the end of
+// the test.
+func New(t testing.TB) *FS {
+	t.Helper()
+	fs := &FS{t: t, Path: t.TempDir()}
+	fs.WriteFile("stat", fmt.Sprintf("cpu  0 0 0 0 0 0 0 0 0 0\nbtime %d\n", BootTime))
+	return fs
+}
+
+// WriteFile writes a file of the filesystem, creating its directory.
+func (fs *FS) WriteFile(name, content string) {
+	fs.t.Helper()
+	path := filepath.Join(fs.Path, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		fs.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		fs.t.Fatal(err)
+	}
+}
+
+// AddProcess adds process pid to the filesystem.
+func (fs *FS) AddProcess(pid int, p Process) {
+	fs.t.Helper()
+	dir := strconv.Itoa(pid)
+	fs.WriteFile(filepath.Join(dir, "stat"), fmt.Sprintf(
+		"%d (%s) S 1 %d %d 0 -1 4194560 100 0 0 0 %d %d 0 0 20 0 %d 0 %d %d %d 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0\n",
+		pid, p.Comm, pid, pid, p.UTime, p.STime, p.Threads, p.StartTime, p.VSize, p.RSS))
+	fs.WriteFile(filepath.Join(dir, "status"), fmt.Sprintf(
+		"Name:\t%s\nPid:\t%d\nThreads:\t%d\nvoluntary_ctxt_switches:\t%d\nnonvoluntary_ctxt_switches:\t%d\n",
+		p.Comm, pid, p.Threads, p.VoluntaryCtxtSwitches, p.NonVoluntaryCtxtSwitches))
+	fs.WriteFile(filepath.Join(dir, "limits"), fmt.Sprintf(
+		"Limit                     Soft Limit           Hard Limit           Units     \n"+
+			"Max open files            %-20d %-20d files     \n", p.MaxFDs, p.MaxFDs))
+	fs.WriteFile(filepath.Join(dir, "cmdline"), p.Comm+"\x00")
+	fs.WriteFile(filepath.Join(dir, "comm"), p.Comm+"\n")
+	if p.Cgroup != "" {
+		fs.WriteFile(filepath.Join(dir, "cgroup"), p.Cgroup)
+	}
+	if err := os.MkdirAll(filepath.Join(fs.Path, dir, "fd"), 0o755); err != nil {
+		fs.t.Fatal(err)
+	}
+	for i := 0; i < p.FDs; i++ {
+		fs.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(i)), "")
+	}
+	for i, inode := range p.Sockets {
+		fd := filepath.Join(fs.Path, dir, "fd", strconv.Itoa(p.FDs+i))
+		if err := os.Symlink(fmt.Sprintf("socket:[%d]", inode), fd); err != nil {
+			fs.t.Fatal(err)
+		}
+	}
+}
+
+// AddTCPSocket adds the TCP socket with the given inode and local address,
+// e.g. "127.0.0.1:11211", to net/tcp or net/tcp6. It is listening or
+// established.
+func (fs *FS) AddTCPSocket(inode uint64, address string, listening bool) {
+	fs.t.Helper()
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		fs.t.Fatal(err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		fs.t.Fatal(err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		fs.t.Fatalf("invalid IP %q", host)
+	}
+	state := "01"
+	if listening {
+		state = "0A"
+	}
+	ip4 := ip.To4()
+	if ip4 != nil {
+		ip = ip4
+	}
+	// Each 32 bit word of the address is in host byte order.
+	var hexIP strings.Builder
+	for i := 0; i < len(ip); i += 4 {
+		fmt.Fprintf(&hexIP, "%02X%02X%02X%02X", ip[i+3], ip[i+2], ip[i+1], ip[i])
+	}
+	lines, name := &fs.tcp, "net/tcp"
+	if ip4 == nil {
+		lines, name = &fs.tcp6, "net/tcp6"
+	}
+	*lines = append(*lines, fmt.Sprintf("%4d: %s:%04X %s:0000 %s 00000000:00000000 00:00000000 00000000     0        0 %d 1 0000000000000000 100 0 0 10 0\n",
+		len(*lines), hexIP.String(), p, strings.Repeat("0", hexIP.Len()), state, inode))
+	fs.WriteFile(name, "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"+strings.Join(*lines, ""))
+}
+
+// AddUnixSocket adds the unix stream socket with the given inode and path to
+// net/unix. It is listening or connected.
+func (fs *FS) AddUnixSocket(inode uint64, path string, listening bool) {
+	fs.t.Helper()
+	flags, state := "00000000", "03"
+	if listening {
+		flags, state = "00010000", "01"
+	}
+	fs.unix = append(fs.unix, fmt.Sprintf("0000000000000000: 00000002 00000000 %s 0001 %s %d %s\n", flags, state, inode, path))
+	fs.WriteFile("net/unix", "Num       RefCount Protocol Flags    Type St Inode Path\n"+strings.Join(fs.unix, ""))
+}
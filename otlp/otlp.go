@@ -0,0 +1,337 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp pushes the metrics of a Prometheus gatherer to an OpenTelemetry
+// collector over OTLP/HTTP.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// serverLabel is the label identifying the memcached server of a metric. It
+// becomes the service.instance.id attribute of the resource of the metric.
+const serverLabel = "server"
+
+// Pusher periodically pushes the metrics of a gatherer to an OTLP/HTTP
+// endpoint.
+type Pusher struct {
+	endpoint   string
+	gatherer   prometheus.Gatherer
+	logger     log.Logger
+	client     *http.Client
+	headers    map[string]string
+	attributes map[string]string
+	start      time.Time
+	now        func() time.Time
+}
+
+// Option configures a Pusher.
+type Option func(*Pusher)
+
+// WithHeaders sets additional headers of the push requests, e.g. for
+// authentication.
+func WithHeaders(headers map[string]string) Option {
+	return func(p *Pusher) {
+		p.headers = headers
+	}
+}
+
+// WithResourceAttributes sets additional attributes of the resources of all
+// metrics.
+func WithResourceAttributes(attributes map[string]string) Option {
+	return func(p *Pusher) {
+		p.attributes = attributes
+	}
+}
+
+// WithClient sets the HTTP client of the push requests.
+func WithClient(client *http.Client) Option {
+	return func(p *Pusher) {
+		p.client = client
+	}
+}
+
+// New returns a Pusher sending the metrics of gatherer to endpoint, the URL of
+// the metrics endpoint of an OTLP/HTTP receiver, e.g.
+// http://localhost:4318/v1/metrics.
+func New(endpoint string, gatherer prometheus.Gatherer, logger log.Logger, opts ...Option) *Pusher {
+	p := &Pusher{
+		endpoint: endpoint,
+		gatherer: gatherer,
+		logger:   logger,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		start:    time.Now(),
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Run pushes the metrics at every interval until ctx is done.
+func (p *Pusher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Push(ctx); err != nil {
+			level.Error(p.logger).Log("msg", "Failed to push metrics over OTLP", "endpoint", p.endpoint, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Push gathers the metrics and pushes them once.
+func (p *Pusher) Push(ctx context.Context) error {
+	mfs, gatherErr := p.gatherer.Gather()
+	if len(mfs) == 0 {
+		return gatherErr
+	}
+	body, err := proto.Marshal(p.metricsData(mfs))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "memcached_exporter/"+version.Version)
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return gatherErr
+}
+
+// metricsData converts the gathered metric families, grouping their metrics
+// into one resource per server.
+func (p *Pusher) metricsData(mfs []*dto.MetricFamily) *metricspb.MetricsData {
+	now := uint64(p.now().UnixNano())
+	resources := map[string]*metricspb.ScopeMetrics{}
+	metrics := map[string]map[string]*metricspb.Metric{}
+	var servers []string
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			server, attributes := splitLabels(m.Label)
+			scope, ok := resources[server]
+			if !ok {
+				scope = &metricspb.ScopeMetrics{
+					Scope: &commonpb.InstrumentationScope{Name: "memcached_exporter", Version: version.Version},
+				}
+				resources[server] = scope
+				metrics[server] = map[string]*metricspb.Metric{}
+				servers = append(servers, server)
+			}
+			metric, ok := metrics[server][mf.GetName()]
+			if !ok {
+				metric = newMetric(mf)
+				if metric == nil {
+					continue
+				}
+				metrics[server][mf.GetName()] = metric
+				scope.Metrics = append(scope.Metrics, metric)
+			}
+
+			t := now
+			if m.TimestampMs != nil {
+				t = uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+			}
+			p.addDataPoint(metric, m, attributes, t)
+		}
+	}
+
+	sort.Strings(servers)
+	data := &metricspb.MetricsData{}
+	for _, server := range servers {
+		data.ResourceMetrics = append(data.ResourceMetrics, &metricspb.ResourceMetrics{
+			Resource:     p.resource(server),
+			ScopeMetrics: []*metricspb.ScopeMetrics{resources[server]},
+		})
+	}
+	return data
+}
+
+// resource returns the resource of the metrics of server, which is empty for
+// metrics not related to a single server.
+func (p *Pusher) resource(server string) *resourcepb.Resource {
+	attributes := map[string]string{"service.name": "memcached"}
+	if server != "" {
+		attributes["service.instance.id"] = server
+	}
+	for name, value := range p.attributes {
+		attributes[name] = value
+	}
+	return &resourcepb.Resource{Attributes: keyValues(attributes)}
+}
+
+// newMetric returns an empty metric of the type of mf, or nil if the type is
+// not supported.
+func newMetric(mf *dto.MetricFamily) *metricspb.Metric {
+	metric := &metricspb.Metric{Name: mf.GetName(), Description: mf.GetHelp()}
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+	case dto.MetricType_HISTOGRAM:
+		metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		}}
+	case dto.MetricType_SUMMARY:
+		metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{}}
+	default:
+		return nil
+	}
+	return metric
+}
+
+// addDataPoint adds m as data point to metric. Cumulative data points start
+// at the created timestamp of m, or else when the pusher was created.
+func (p *Pusher) addDataPoint(metric *metricspb.Metric, m *dto.Metric, attributes []*commonpb.KeyValue, t uint64) {
+	start := uint64(p.start.UnixNano())
+	switch data := metric.Data.(type) {
+	case *metricspb.Metric_Sum:
+		if created := m.GetCounter().GetCreatedTimestamp(); created != nil {
+			start = uint64(created.AsTime().UnixNano())
+		}
+		data.Sum.DataPoints = append(data.Sum.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        attributes,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      t,
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetCounter().GetValue()},
+		})
+	case *metricspb.Metric_Gauge:
+		value := m.GetGauge().GetValue()
+		if m.Untyped != nil {
+			value = m.GetUntyped().GetValue()
+		}
+		data.Gauge.DataPoints = append(data.Gauge.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: t,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		})
+	case *metricspb.Metric_Histogram:
+		h := m.GetHistogram()
+		if created := h.GetCreatedTimestamp(); created != nil {
+			start = uint64(created.AsTime().UnixNano())
+		}
+		sum := h.GetSampleSum()
+		point := &metricspb.HistogramDataPoint{
+			Attributes:        attributes,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      t,
+			Count:             h.GetSampleCount(),
+			Sum:               &sum,
+		}
+		// Prometheus buckets are cumulative, OTLP buckets are not.
+		var previous uint64
+		for _, b := range h.Bucket {
+			point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+			point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-previous)
+			previous = b.GetCumulativeCount()
+		}
+		point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+		data.Histogram.DataPoints = append(data.Histogram.DataPoints, point)
+	case *metricspb.Metric_Summary:
+		s := m.GetSummary()
+		if created := s.GetCreatedTimestamp(); created != nil {
+			start = uint64(created.AsTime().UnixNano())
+		}
+		point := &metricspb.SummaryDataPoint{
+			Attributes:        attributes,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      t,
+			Count:             s.GetSampleCount(),
+			Sum:               s.GetSampleSum(),
+		}
+		for _, q := range s.Quantile {
+			point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+				Quantile: q.GetQuantile(),
+				Value:    q.GetValue(),
+			})
+		}
+		data.Summary.DataPoints = append(data.Summary.DataPoints, point)
+	}
+}
+
+// splitLabels returns the value of the server label and the other labels as
+// attributes.
+func splitLabels(labels []*dto.LabelPair) (string, []*commonpb.KeyValue) {
+	var server string
+	attributes := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, lp := range labels {
+		if lp.GetName() == serverLabel {
+			server = lp.GetValue()
+			continue
+		}
+		attributes = append(attributes, keyValue(lp.GetName(), lp.GetValue()))
+	}
+	return server, attributes
+}
+
+// keyValues converts attributes, sorted by name.
+func keyValues(attributes map[string]string) []*commonpb.KeyValue {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	kvs := make([]*commonpb.KeyValue, 0, len(names))
+	for _, name := range names {
+		kvs = append(kvs, keyValue(name, attributes[name]))
+	}
+	return kvs
+}
+
+func keyValue(name, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   name,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
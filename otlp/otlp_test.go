@@ -0,0 +1,158 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+)
+
+// receive starts an OTLP/HTTP receiver answering with status, and returns its
+// URL and a channel of the received requests.
+func receive(t *testing.T, status int) (string, <-chan *http.Request, <-chan *metricspb.MetricsData) {
+	t.Helper()
+	requests := make(chan *http.Request, 1)
+	data := make(chan *metricspb.MetricsData, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		d := &metricspb.MetricsData{}
+		if err := proto.Unmarshal(body, d); err != nil {
+			t.Error(err)
+		}
+		requests <- r
+		data <- d
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL + "/v1/metrics", requests, data
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	m := map[string]string{}
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.GetStringValue()
+	}
+	return m
+}
+
+func TestPusher(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		t.Parallel()
+		stats := memcachedtest.Stats()
+		stats[""]["time"] = "1700003600"
+		server := memcachedtest.NewServer(t, stats)
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(exporter.New(server.Addr, time.Second, log.NewNopLogger(), nil))
+		histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "test_duration_seconds",
+			Help:    "Test histogram.",
+			Buckets: []float64{1, 2},
+		})
+		histogram.Observe(0.5)
+		histogram.Observe(1.5)
+		histogram.Observe(3)
+		registry.MustRegister(histogram)
+
+		endpoint, requests, data := receive(t, http.StatusOK)
+		p := New(endpoint, registry, log.NewNopLogger(),
+			WithHeaders(map[string]string{"Authorization": "Bearer secret"}),
+			WithResourceAttributes(map[string]string{"deployment.environment": "test"}),
+		)
+		if err := p.Push(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		r := <-requests
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+			t.Errorf("want content type application/x-protobuf, got %q", ct)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("want authorization header, got %q", auth)
+		}
+
+		d := <-data
+		if len(d.ResourceMetrics) != 2 {
+			t.Fatalf("want 2 resources, got %d", len(d.ResourceMetrics))
+		}
+		want := map[string]string{"service.name": "memcached", "deployment.environment": "test"}
+		if got := attributes(d.ResourceMetrics[0].Resource.Attributes); !reflect.DeepEqual(got, want) {
+			t.Errorf("want resource attributes %v, got %v", want, got)
+		}
+		want = map[string]string{"service.name": "memcached", "service.instance.id": server.Addr, "deployment.environment": "test"}
+		if got := attributes(d.ResourceMetrics[1].Resource.Attributes); !reflect.DeepEqual(got, want) {
+			t.Errorf("want resource attributes %v, got %v", want, got)
+		}
+
+		metrics := map[string]*metricspb.Metric{}
+		for _, rm := range d.ResourceMetrics {
+			for _, m := range rm.ScopeMetrics[0].Metrics {
+				metrics[m.Name] = m
+			}
+		}
+		uptime := metrics["memcached_uptime_seconds"].GetSum()
+		if uptime == nil || !uptime.IsMonotonic || uptime.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			t.Fatalf("want cumulative monotonic sum, got %v", metrics["memcached_uptime_seconds"])
+		}
+		point := uptime.DataPoints[0]
+		if point.GetAsDouble() != 3600 {
+			t.Errorf("want uptime 3600, got %v", point.GetAsDouble())
+		}
+		if start := time.Unix(0, int64(point.StartTimeUnixNano)); !start.Equal(time.Unix(1700000000, 0)) {
+			t.Errorf("want start time 1700000000, got %v", start.Unix())
+		}
+		if len(point.Attributes) != 0 {
+			t.Errorf("want server label moved to the resource, got attributes %v", point.Attributes)
+		}
+		if up := metrics["memcached_up"].GetGauge(); up == nil || up.DataPoints[0].GetAsDouble() != 1 {
+			t.Errorf("want gauge memcached_up 1, got %v", metrics["memcached_up"])
+		}
+
+		h := metrics["test_duration_seconds"].GetHistogram().DataPoints[0]
+		if !reflect.DeepEqual(h.ExplicitBounds, []float64{1, 2}) || !reflect.DeepEqual(h.BucketCounts, []uint64{1, 1, 1}) {
+			t.Errorf("want bounds [1 2] and counts [1 1 1], got %v and %v", h.ExplicitBounds, h.BucketCounts)
+		}
+		if h.Count != 3 || h.GetSum() != 5 {
+			t.Errorf("want count 3 and sum 5, got %d and %v", h.Count, h.GetSum())
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		t.Parallel()
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test", Help: "Test gauge."}))
+
+		endpoint, _, _ := receive(t, http.StatusBadRequest)
+		p := New(endpoint, registry, log.NewNopLogger())
+		if err := p.Push(context.Background()); err == nil {
+			t.Error("expected error on HTTP status 400")
+		}
+	})
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache keeps the answers of servers to the stats commands for a minimum
+// refresh interval, so that the load on memcached does not grow with the number
+// of scrapers. It is safe for concurrent use and can be shared by exporters,
+// which each parse the answers into their own metrics. Concurrent misses of the
+// same key are queried once.
+type Cache struct {
+	interval time.Duration
+	now      func() time.Time
+	group    singleflight.Group
+
+	mutex   sync.Mutex
+	entries map[string]*cachedStats
+}
+
+// cachedStats is the answer of a server at a point in time.
+type cachedStats struct {
+	time  time.Time
+	stats *serverStats
+}
+
+// NewCache returns a cache serving the results of a collection for interval.
+func NewCache(interval time.Duration) *Cache {
+	return &Cache{
+		interval: interval,
+		now:      time.Now,
+		entries:  map[string]*cachedStats{},
+	}
+}
+
+// get returns the entry of key if it is younger than the interval, or nil.
+func (c *Cache) get(key string) *cachedStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := c.entries[key]
+	if entry == nil || c.now().Sub(entry.time) >= c.interval {
+		return nil
+	}
+	return entry
+}
+
+// put stores the entry of key, and drops the entries that expired.
+func (c *Cache) put(key string, entry *cachedStats) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if now.Sub(e.time) >= c.interval {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry
+}
+
+// load returns the entry of key, calling query to query the server if it is
+// missing or expired. Callers that miss while key is being queried wait for it
+// and share its entry.
+func (c *Cache) load(key string, query func() *serverStats) *cachedStats {
+	if entry := c.get(key); entry != nil {
+		return entry
+	}
+	v, _, _ := c.group.Do(key, func() (interface{}, error) {
+		// The entry may have been stored since the miss above.
+		if entry := c.get(key); entry != nil {
+			return entry, nil
+		}
+		entry := &cachedStats{stats: query()}
+		entry.time = c.now()
+		c.put(key, entry)
+		return entry, nil
+	})
+	return v.(*cachedStats)
+}
+
+// collectCached delivers the metrics of the collectors of set of server from
+// its cached stats, querying it first if they are missing or expired. The
+// metrics carry the time of the query as their timestamp.
+func (e *Exporter) collectCached(ch chan<- prometheus.Metric, server string, set collectorSet) map[string]string {
+	key := strings.Join([]string{server, e.module, set.key()}, "\x00")
+	queried := false
+	entry := e.cache.load(key, func() *serverStats {
+		queried = true
+		return e.queryServer(server, set)
+	})
+
+	timestamped := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range timestamped {
+			ch <- prometheus.NewMetricWithTimestamp(entry.time, m)
+		}
+		close(done)
+	}()
+	stats := e.emitServer(timestamped, server, set, entry.stats, queried)
+	close(timestamped)
+	<-done
+	ch <- prometheus.MustNewConstMetric(e.cacheAge, prometheus.GaugeValue, e.cache.now().Sub(entry.time).Seconds(), server)
+	return stats
+}
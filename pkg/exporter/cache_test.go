@@ -0,0 +1,139 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestCache(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	now := time.Unix(1700000000, 0)
+	cache := NewCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithCache(cache))
+	names := []string{"memcached_current_items", "memcached_exporter_cache_age_seconds"}
+	expected := func(items, timestamp, age string) *strings.Reader {
+		return strings.NewReader(strings.NewReplacer("SERVER", server.Addr, "ITEMS", items, "TIMESTAMP", timestamp, "AGE", age).Replace(`
+# HELP memcached_current_items Current number of items stored by this instance.
+# TYPE memcached_current_items gauge
+memcached_current_items{server="SERVER"} ITEMS TIMESTAMP
+# HELP memcached_exporter_cache_age_seconds Number of seconds since the cached samples of the server were collected.
+# TYPE memcached_exporter_cache_age_seconds gauge
+memcached_exporter_cache_age_seconds{server="SERVER"} AGE
+`))
+	}
+
+	stats := memcachedtest.Stats()
+	stats[""]["curr_items"] = "1"
+	server.SetStats("", stats[""])
+	if err := testutil.CollectAndCompare(e, expected("1", "1700000000000", "0"), names...); err != nil {
+		t.Error(err)
+	}
+
+	// Within the interval, the cached samples are served.
+	stats[""]["curr_items"] = "2"
+	server.SetStats("", stats[""])
+	now = now.Add(30 * time.Second)
+	if err := testutil.CollectAndCompare(e, expected("1", "1700000000000", "30"), names...); err != nil {
+		t.Error(err)
+	}
+
+	// Once the interval passed, the server is collected again.
+	now = now.Add(30 * time.Second)
+	if err := testutil.CollectAndCompare(e, expected("2", "1700000060000", "0"), names...); err != nil {
+		t.Error(err)
+	}
+
+	if n := strings.Count(strings.Join(server.Commands(), "\n"), "stats settings"); n != 2 {
+		t.Errorf("server was collected %d times, want 2", n)
+	}
+}
+
+func TestCacheConcurrentMisses(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	cache := NewCache(time.Minute)
+
+	const collectors = 5
+	release := server.Hold()
+	var wg sync.WaitGroup
+	for i := 0; i < collectors; i++ {
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithCache(cache))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if n := testutil.CollectAndCount(e, "memcached_up"); n != 1 {
+				t.Errorf("got %d memcached_up series, want 1", n)
+			}
+		}()
+	}
+	for server.Connections() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	release()
+	wg.Wait()
+
+	if n := server.Connections(); n != 1 {
+		t.Errorf("got %d connections to the server, want 1", n)
+	}
+	if n := strings.Count(strings.Join(server.Commands(), "\n"), "stats settings"); n != 1 {
+		t.Errorf("server was collected %d times, want 1", n)
+	}
+}
+
+func TestCacheSharedByExporters(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	cache := NewCache(time.Minute)
+	targets := []config.TargetConfig{
+		{Match: config.MustNewRegexp(".*"), Alias: "cache", Labels: map[string]string{"env": "prod"}},
+	}
+
+	// Each exporter parses the cached stats into its own metrics.
+	for i := 0; i < 2; i++ {
+		registry := prometheus.NewPedanticRegistry()
+		registry.MustRegister(New(server.Addr, time.Second, log.NewNopLogger(), nil, WithCache(cache), WithTargets(targets)))
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("exporter %d: %v", i, err)
+		}
+		found := false
+		for _, mf := range mfs {
+			if mf.GetName() != "memcached_current_connections" {
+				continue
+			}
+			labels := map[string]string{}
+			for _, lp := range mf.Metric[0].Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			found = labels["server"] == "cache" && labels["env"] == "prod"
+		}
+		if !found {
+			t.Errorf("exporter %d: want memcached_current_connections labelled by the target", i)
+		}
+	}
+	if n := strings.Count(strings.Join(server.Commands(), "\n"), "stats settings"); n != 1 {
+		t.Errorf("server was collected %d times, want 1", n)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+const subsystemCgroup = "cgroup"
+
+// defaultCgroupFS is the usual mount point of the cgroup filesystem.
+const defaultCgroupFS = "/sys/fs/cgroup"
+
+// unlimitedMemory is the smallest memory limit considered unlimited. cgroup v1
+// reports no limit as the largest multiple of the page size below 2^63.
+const unlimitedMemory = 1 << 62
+
+// cgroupDescs are the descriptors of the metrics of the memory cgroups of the
+// memcached processes.
+type cgroupDescs struct {
+	memoryLimit *prometheus.Desc
+	memoryUsage *prometheus.Desc
+	oomKills    *prometheus.Desc
+	sizing      *prometheus.Desc
+}
+
+func newCgroupDescs(newDesc func(string, string, []string, prometheus.Labels) *prometheus.Desc) cgroupDescs {
+	desc := func(name, help string) *prometheus.Desc {
+		return newDesc(prometheus.BuildFQName(Namespace, subsystemCgroup, name), help, []string{"server"}, nil)
+	}
+	return cgroupDescs{
+		memoryLimit: desc("memory_limit_bytes", "Memory limit of the cgroup of the process, including the limits of its parents."),
+		memoryUsage: desc("memory_usage_bytes", "Memory usage of the cgroup of the process."),
+		oomKills:    desc("oom_kills_total", "Number of processes of the cgroup of the process killed by the OOM killer."),
+		sizing: desc("memory_sizing_ratio",
+			"Ratio of limit_maxbytes plus the memory the process uses besides its slab pages to the memory limit of its cgroup. "+
+				"Above 1, the process may be OOM killed before its cache is full."),
+	}
+}
+
+func (d cgroupDescs) all() []*prometheus.Desc {
+	return []*prometheus.Desc{d.memoryLimit, d.memoryUsage, d.oomKills, d.sizing}
+}
+
+// WithCgroupFS sets the mount point of the cgroup filesystem from which the
+// memory of the cgroups of the memcached processes is read. It defaults to
+// /sys/fs/cgroup.
+func WithCgroupFS(path string) Option {
+	return func(e *Exporter) {
+		e.cgroupFS = path
+	}
+}
+
+// cgroupMemory is the memory accounting of a cgroup.
+type cgroupMemory struct {
+	// limit is 0 if the cgroup and its parents have no limit.
+	limit    uint64
+	usage    uint64
+	oomKills uint64
+	// hasOOMKills is false on kernels that do not count OOM kills.
+	hasOOMKills bool
+}
+
+// collectCgroup delivers the memory of the cgroup of the process of server,
+// if its pid is known. stats are the general stats of the server, or nil if
+// it is down.
+func (e *Exporter) collectCgroup(ch chan<- prometheus.Metric, server string, stats map[string]string) {
+	pid, err := e.lookupPID(server)
+	if err == nil && pid == 0 {
+		return
+	}
+	var m cgroupMemory
+	if err == nil {
+		m, err = readCgroupMemory(e.procFS, e.cgroupFS, pid)
+	}
+	if err != nil {
+		level.Warn(e.logger).Log("msg", "Failed to collect cgroup metrics", "server", server, "err", err)
+		return
+	}
+
+	d := e.cgroup
+	ch <- prometheus.MustNewConstMetric(d.memoryUsage, prometheus.GaugeValue, float64(m.usage), server)
+	if m.hasOOMKills {
+		ch <- prometheus.MustNewConstMetric(d.oomKills, prometheus.CounterValue, float64(m.oomKills), server)
+	}
+	if m.limit == 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.memoryLimit, prometheus.GaugeValue, float64(m.limit), server)
+
+	// The overhead is what the process uses besides the slab pages, like
+	// the hash table and the connection buffers, which grows with the cache
+	// until limit_maxbytes is allocated to slab pages.
+	maxBytes, err := strconv.ParseFloat(stats["limit_maxbytes"], 64)
+	if err != nil {
+		return
+	}
+	malloced, err := strconv.ParseFloat(stats["total_malloced"], 64)
+	if err != nil {
+		return
+	}
+	overhead := float64(m.usage) - malloced
+	if overhead < 0 {
+		overhead = 0
+	}
+	ch <- prometheus.MustNewConstMetric(d.sizing, prometheus.GaugeValue, (maxBytes+overhead)/float64(m.limit), server)
+}
+
+// readCgroupMemory reads the memory of the cgroup of process pid, from the
+// memory controller of cgroup v1 if it is mounted, or else from cgroup v2.
+func readCgroupMemory(procFS, cgroupFS string, pid int) (cgroupMemory, error) {
+	fs, err := procfs.NewFS(procFS)
+	if err != nil {
+		return cgroupMemory{}, err
+	}
+	p, err := fs.Proc(pid)
+	if err != nil {
+		return cgroupMemory{}, err
+	}
+	cgroups, err := p.Cgroups()
+	if err != nil {
+		return cgroupMemory{}, err
+	}
+	var unified *procfs.Cgroup
+	for i, cg := range cgroups {
+		for _, controller := range cg.Controllers {
+			if controller == "memory" {
+				return readCgroupV1Memory(filepath.Join(cgroupFS, "memory", cg.Path))
+			}
+		}
+		if cg.HierarchyID == 0 {
+			unified = &cgroups[i]
+		}
+	}
+	if unified == nil {
+		return cgroupMemory{}, fmt.Errorf("process %d has no memory cgroup", pid)
+	}
+	return readCgroupV2Memory(cgroupFS, unified.Path)
+}
+
+func readCgroupV1Memory(dir string) (cgroupMemory, error) {
+	var m cgroupMemory
+	var err error
+	if m.usage, err = readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes")); err != nil {
+		return m, err
+	}
+	// The hierarchical limit accounts for the limits of the parents.
+	stat, err := readCgroupKeys(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return m, err
+	}
+	if limit, ok := stat["hierarchical_memory_limit"]; ok && limit < unlimitedMemory {
+		m.limit = limit
+	}
+	oom, err := readCgroupKeys(filepath.Join(dir, "memory.oom_control"))
+	if err != nil {
+		return m, err
+	}
+	m.oomKills, m.hasOOMKills = oom["oom_kill"]
+	return m, nil
+}
+
+func readCgroupV2Memory(cgroupFS, cgroupPath string) (cgroupMemory, error) {
+	var m cgroupMemory
+	dir := filepath.Join(cgroupFS, cgroupPath)
+	var err error
+	if m.usage, err = readCgroupValue(filepath.Join(dir, "memory.current")); err != nil {
+		return m, err
+	}
+	events, err := readCgroupKeys(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return m, err
+	}
+	m.oomKills, m.hasOOMKills = events["oom_kill"]
+
+	// The limit is the lowest of the cgroup and its parents. The root cgroup
+	// has no limit.
+	for p := path.Clean("/" + cgroupPath); p != "/"; p = path.Dir(p) {
+		limit, err := readCgroupValue(filepath.Join(cgroupFS, p, "memory.max"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return m, err
+		}
+		if limit < unlimitedMemory && (m.limit == 0 || limit < m.limit) {
+			m.limit = limit
+		}
+	}
+	return m, nil
+}
+
+// readCgroupValue reads a file of a single value, where "max" means no limit.
+func readCgroupValue(name string) (uint64, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return unlimitedMemory, nil
+	}
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// readCgroupKeys reads a file of "key value" lines.
+func readCgroupKeys(name string) (map[string]uint64, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, scanner.Err()
+}
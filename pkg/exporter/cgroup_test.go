@@ -0,0 +1,111 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/internal/procfstest"
+)
+
+func TestCgroup(t *testing.T) {
+	stats := memcachedtest.Stats()
+	stats[""]["limit_maxbytes"] = "67108864"
+	a := memcachedtest.NewServer(t, stats)
+	b := memcachedtest.NewServer(t, stats)
+	c := memcachedtest.NewServer(t, stats)
+
+	fs := procfstest.New(t)
+	fs.AddProcess(100, procfstest.Process{Comm: "memcached", Cgroup: "0::/system.slice/memcached.service\n"})
+	fs.AddProcess(200, procfstest.Process{Comm: "memcached", Cgroup: "12:memory:/docker/abc\n11:cpu,cpuacct:/docker/abc\n0::/\n"})
+	fs.AddProcess(300, procfstest.Process{Comm: "memcached", Cgroup: "12:memory:/\n"})
+
+	cgroupFS := t.TempDir()
+	writeFiles(t, cgroupFS, map[string]string{
+		// cgroup v2, limited by the parent.
+		"system.slice/memory.max":                       "134217728\n",
+		"system.slice/memcached.service/memory.max":     "max\n",
+		"system.slice/memcached.service/memory.current": "83886080\n",
+		"system.slice/memcached.service/memory.events":  "low 0\nhigh 0\nmax 2\noom 1\noom_kill 1\n",
+		// cgroup v1, on a kernel that does not count OOM kills.
+		"memory/docker/abc/memory.usage_in_bytes": "33554432\n",
+		"memory/docker/abc/memory.stat":           "cache 0\nrss 33554432\nhierarchical_memory_limit 268435456\n",
+		"memory/docker/abc/memory.oom_control":    "oom_kill_disable 0\nunder_oom 0\n",
+		// cgroup v1, unlimited.
+		"memory/memory.usage_in_bytes": "1073741824\n",
+		"memory/memory.stat":           "cache 0\nhierarchical_memory_limit 9223372036854771712\n",
+		"memory/memory.oom_control":    "oom_kill_disable 0\nunder_oom 0\noom_kill 0\n",
+	})
+	e := New("", time.Second, log.NewNopLogger(), nil, WithProcFS(fs.Path), WithCgroupFS(cgroupFS))
+	e.SetAddresses([]string{a.Addr, b.Addr, c.Addr}, map[string]int{a.Addr: 100, b.Addr: 200, c.Addr: 300})
+	expected := `
+# HELP memcached_cgroup_memory_limit_bytes Memory limit of the cgroup of the process, including the limits of its parents.
+# TYPE memcached_cgroup_memory_limit_bytes gauge
+memcached_cgroup_memory_limit_bytes{server="A"} 1.34217728e+08
+memcached_cgroup_memory_limit_bytes{server="B"} 2.68435456e+08
+# HELP memcached_cgroup_memory_sizing_ratio Ratio of limit_maxbytes plus the memory the process uses besides its slab pages to the memory limit of its cgroup. Above 1, the process may be OOM killed before its cache is full.
+# TYPE memcached_cgroup_memory_sizing_ratio gauge
+memcached_cgroup_memory_sizing_ratio{server="A"} 1.1171875
+memcached_cgroup_memory_sizing_ratio{server="B"} 0.37109375
+# HELP memcached_cgroup_memory_usage_bytes Memory usage of the cgroup of the process.
+# TYPE memcached_cgroup_memory_usage_bytes gauge
+memcached_cgroup_memory_usage_bytes{server="A"} 8.388608e+07
+memcached_cgroup_memory_usage_bytes{server="B"} 3.3554432e+07
+memcached_cgroup_memory_usage_bytes{server="C"} 1.073741824e+09
+# HELP memcached_cgroup_oom_kills_total Number of processes of the cgroup of the process killed by the OOM killer.
+# TYPE memcached_cgroup_oom_kills_total counter
+memcached_cgroup_oom_kills_total{server="A"} 1
+memcached_cgroup_oom_kills_total{server="C"} 0
+`
+	expected = strings.NewReplacer(`"A"`, `"`+a.Addr+`"`, `"B"`, `"`+b.Addr+`"`, `"C"`, `"`+c.Addr+`"`).Replace(expected)
+	names := []string{
+		"memcached_cgroup_memory_limit_bytes", "memcached_cgroup_memory_sizing_ratio",
+		"memcached_cgroup_memory_usage_bytes", "memcached_cgroup_oom_kills_total",
+	}
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	// The stats the sizing is based on are queried without their collectors.
+	filtered, err := e.Filter([]string{CollectorCgroup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(filtered, "memcached_cgroup_memory_sizing_ratio"); n != 2 {
+		t.Errorf("want 2 sizing ratios with only the cgroup collector, got %d", n)
+	}
+	if n := testutil.CollectAndCount(filtered, "memcached_malloced_bytes", "memcached_limit_bytes"); n != 0 {
+		t.Errorf("want no metrics of the general and slabs collectors, got %d", n)
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clusterGauge is a gauge of the general stats that is aggregated across the
+// members of a pool.
+type clusterGauge struct {
+	key           string
+	sum, min, max *prometheus.Desc
+}
+
+// clusterCounter is a counter of the general stats that is summed up across
+// the members of a pool.
+type clusterCounter struct {
+	key         string
+	desc        *prometheus.Desc
+	labelValues []string
+}
+
+func newClusterGauge(newDesc func(string, string, []string, prometheus.Labels) *prometheus.Desc, key, name, help string) clusterGauge {
+	return clusterGauge{
+		key: key,
+		sum: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, name),
+			help+" Summed up across the members of the pool that are up.",
+			[]string{"pool"},
+			nil,
+		),
+		min: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, name+"_min"),
+			help+" Minimum across the members of the pool that are up.",
+			[]string{"pool"},
+			nil,
+		),
+		max: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, name+"_max"),
+			help+" Maximum across the members of the pool that are up.",
+			[]string{"pool"},
+			nil,
+		),
+	}
+}
+
+// collectCluster aggregates the general stats of the members of the pool.
+// Gauges only take the members that are up into account. Counters use the last
+// collected value of members that are down, so that their sum does not drop
+// because of a failed collection.
+func (e *Exporter) collectCluster(ch chan<- prometheus.Metric, p *pool, stats map[string]map[string]string) {
+	up := 0
+	for _, s := range p.Servers {
+		if stats[s.Address] != nil {
+			up++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(e.clusterMembers, prometheus.GaugeValue, float64(len(p.Servers)), p.Name)
+	ch <- prometheus.MustNewConstMetric(e.clusterMembersUp, prometheus.GaugeValue, float64(up), p.Name)
+
+	for _, g := range e.clusterGauges {
+		sum, min, max := 0., math.Inf(1), math.Inf(-1)
+		n := 0
+		for _, s := range p.Servers {
+			v, err := parse(stats[s.Address], g.key, e.logger)
+			if err != nil {
+				continue
+			}
+			sum += v
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(g.sum, prometheus.GaugeValue, sum, p.Name)
+		ch <- prometheus.MustNewConstMetric(g.min, prometheus.GaugeValue, min, p.Name)
+		ch <- prometheus.MustNewConstMetric(g.max, prometheus.GaugeValue, max, p.Name)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, c := range e.clusterCounters {
+		sum := 0.
+		n := 0
+		for _, s := range p.Servers {
+			key := poolServer{p.Name, s.Address}
+			if e.lastCounters[key] == nil {
+				e.lastCounters[key] = map[string]float64{}
+			}
+			if v, err := parse(stats[s.Address], c.key, e.logger); err == nil {
+				e.lastCounters[key][c.key] = v
+			}
+			if v, ok := e.lastCounters[key][c.key]; ok {
+				sum += v
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, sum, append(append([]string{}, c.labelValues...), p.Name)...)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+func TestCollectCluster(t *testing.T) {
+	e := New("a:11211,b:11211", 100*time.Millisecond, log.NewNopLogger(), nil, WithPools([]config.PoolConfig{
+		{
+			Name:      "sessions",
+			Aggregate: true,
+			Servers: []config.PoolServerConfig{
+				{Address: "a:11211", Weight: 1},
+				{Address: "b:11211", Weight: 1},
+			},
+		},
+	}))
+
+	collect := func(stats map[string]map[string]string) *prometheus.Registry {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectorFunc{
+			describe: e.Describe,
+			collect: func(ch chan<- prometheus.Metric) {
+				e.collectCluster(ch, &e.pools[0], stats)
+			},
+		})
+		return registry
+	}
+	names := []string{
+		"memcached_cluster_members",
+		"memcached_cluster_members_up",
+		"memcached_cluster_current_items",
+		"memcached_cluster_current_items_min",
+		"memcached_cluster_current_items_max",
+		"memcached_cluster_items_evicted_total",
+	}
+
+	both := collect(map[string]map[string]string{
+		"a:11211": {"curr_items": "10", "evictions": "1"},
+		"b:11211": {"curr_items": "30", "evictions": "2"},
+	})
+	expected := `
+# HELP memcached_cluster_current_items Current number of items stored. Summed up across the members of the pool that are up.
+# TYPE memcached_cluster_current_items gauge
+memcached_cluster_current_items{pool="sessions"} 40
+# HELP memcached_cluster_current_items_max Current number of items stored. Maximum across the members of the pool that are up.
+# TYPE memcached_cluster_current_items_max gauge
+memcached_cluster_current_items_max{pool="sessions"} 30
+# HELP memcached_cluster_current_items_min Current number of items stored. Minimum across the members of the pool that are up.
+# TYPE memcached_cluster_current_items_min gauge
+memcached_cluster_current_items_min{pool="sessions"} 10
+# HELP memcached_cluster_items_evicted_total Total number of valid items removed from cache to free memory for new items, summed up across the members of the pool.
+# TYPE memcached_cluster_items_evicted_total counter
+memcached_cluster_items_evicted_total{pool="sessions"} 3
+# HELP memcached_cluster_members Number of servers in the pool.
+# TYPE memcached_cluster_members gauge
+memcached_cluster_members{pool="sessions"} 2
+# HELP memcached_cluster_members_up Number of servers in the pool that could be collected.
+# TYPE memcached_cluster_members_up gauge
+memcached_cluster_members_up{pool="sessions"} 2
+`
+	if err := testutil.GatherAndCompare(both, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	partial := collect(map[string]map[string]string{
+		"a:11211": {"curr_items": "12", "evictions": "4"},
+	})
+	expected = `
+# HELP memcached_cluster_current_items Current number of items stored. Summed up across the members of the pool that are up.
+# TYPE memcached_cluster_current_items gauge
+memcached_cluster_current_items{pool="sessions"} 12
+# HELP memcached_cluster_current_items_max Current number of items stored. Maximum across the members of the pool that are up.
+# TYPE memcached_cluster_current_items_max gauge
+memcached_cluster_current_items_max{pool="sessions"} 12
+# HELP memcached_cluster_current_items_min Current number of items stored. Minimum across the members of the pool that are up.
+# TYPE memcached_cluster_current_items_min gauge
+memcached_cluster_current_items_min{pool="sessions"} 12
+# HELP memcached_cluster_items_evicted_total Total number of valid items removed from cache to free memory for new items, summed up across the members of the pool.
+# TYPE memcached_cluster_items_evicted_total counter
+memcached_cluster_items_evicted_total{pool="sessions"} 6
+# HELP memcached_cluster_members Number of servers in the pool.
+# TYPE memcached_cluster_members gauge
+memcached_cluster_members{pool="sessions"} 2
+# HELP memcached_cluster_members_up Number of servers in the pool that could be collected.
+# TYPE memcached_cluster_members_up gauge
+memcached_cluster_members_up{pool="sessions"} 1
+`
+	if err := testutil.GatherAndCompare(partial, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Names of the collectors, i.e. the groups of metrics that can be enabled and
+// disabled separately.
+const (
+	CollectorGeneral  = "general"
+	CollectorSettings = "settings"
+	CollectorSlabs    = "slabs"
+	CollectorItems    = "items"
+	CollectorExtstore = "extstore"
+	CollectorProcess  = "process"
+	CollectorCgroup   = "cgroup"
+)
+
+// Collectors are the names of all collectors.
+var Collectors = []string{
+	CollectorGeneral,
+	CollectorSettings,
+	CollectorSlabs,
+	CollectorItems,
+	CollectorExtstore,
+	CollectorProcess,
+	CollectorCgroup,
+}
+
+// collectorSet is a set of collector names.
+type collectorSet map[string]bool
+
+func newCollectorSet(names ...string) collectorSet {
+	set := collectorSet{}
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// key returns a string identifying the set.
+func (s collectorSet) key() string {
+	names := make([]string, 0, len(s))
+	for name, enabled := range s {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// WithCollectors sets the collectors that are enabled. By default, all
+// collectors are enabled.
+func WithCollectors(names ...string) Option {
+	return func(e *Exporter) {
+		e.collectors = newCollectorSet(names...)
+	}
+}
+
+// Filter returns a collector of the exporter that only collects the given
+// collectors, which must be enabled.
+func (e *Exporter) Filter(names []string) (prometheus.Collector, error) {
+	set := collectorSet{}
+	for _, name := range names {
+		if !e.collectors[name] {
+			if _, ok := e.collectorDescs[name]; !ok {
+				return nil, fmt.Errorf("unknown collector %q", name)
+			}
+			return nil, fmt.Errorf("collector %q is disabled", name)
+		}
+		set[name] = true
+	}
+	return &filteredExporter{Exporter: e, collectors: set}, nil
+}
+
+type filteredExporter struct {
+	*Exporter
+	collectors collectorSet
+}
+
+// Collect implements prometheus.Collector.
+func (f *filteredExporter) Collect(ch chan<- prometheus.Metric) {
+	f.collect(ch, f.collectors)
+}
+
+// newCollectorDescs returns the descriptors of the metrics of each collector.
+func (e *Exporter) newCollectorDescs() map[string][]*prometheus.Desc {
+	return map[string][]*prometheus.Desc{
+		CollectorGeneral: {
+			e.version, e.commands, e.uptime, e.startTime, e.restarts, e.time, e.rusageUser, e.rusageSystem,
+			e.currentBytes, e.limitBytes, e.items, e.itemsTotal, e.bytesRead,
+			e.bytesWritten, e.currentConnections, e.connectionsTotal,
+			e.rejectedConnections, e.connsYieldedTotal, e.listenerDisabledTotal,
+			e.evictions, e.reclaimed, e.lruCrawlerStarts, e.lruCrawlerItemsChecked,
+			e.lruCrawlerReclaimed, e.lruCrawlerMovesToCold, e.lruCrawlerMovesToWarm,
+			e.lruCrawlerMovesWithinLru, e.acceptingConnections,
+		},
+		CollectorSettings: {
+			e.maxConnections, e.settingsDrift, e.lruCrawlerEnabled, e.lruCrawlerSleep,
+			e.lruCrawlerMaxItems, e.lruMaintainerThread, e.lruHotPercent,
+			e.lruWarmPercent, e.lruHotMaxAgeFactor, e.lruWarmMaxAgeFactor,
+		},
+		CollectorSlabs: {
+			e.malloced, e.slabsCommands, e.slabsChunkSize, e.slabsChunksPerPage,
+			e.slabsCurrentPages, e.slabsCurrentChunks, e.slabsChunksUsed,
+			e.slabsChunksFree, e.slabsChunksFreeEnd, e.slabsMemRequested,
+		},
+		CollectorItems: {
+			e.itemsNumber, e.itemsAge, e.itemsLruHits, e.itemsCrawlerReclaimed,
+			e.itemsEvicted, e.itemsEvictedNonzero, e.itemsEvictedTime,
+			e.itemsEvictedUnfetched, e.itemsExpiredUnfetched, e.itemsOutofmemory,
+			e.itemsReclaimed, e.itemsTailrepairs, e.itemsMovesToCold,
+			e.itemsMovesToWarm, e.itemsMovesWithinLru, e.itemsHot, e.itemsWarm,
+			e.itemsCold, e.itemsTemporary, e.itemsAgeOldestHot, e.itemsAgeOldestWarm,
+		},
+		CollectorExtstore: {
+			e.extstoreCompactLost, e.extstoreCompactRescues, e.extstoreCompactSkipped,
+			e.extstorePageAllocs, e.extstorePageEvictions, e.extstorePageReclaims,
+			e.extstorePagesFree, e.extstorePagesUsed, e.extstoreObjectsEvicted,
+			e.extstoreObjectsRead, e.extstoreObjectsWritten, e.extstoreObjectsUsed,
+			e.extstoreBytesEvicted, e.extstoreBytesWritten, e.extstoreBytesRead,
+			e.extstoreBytesUsed, e.extstoreBytesFragmented, e.extstoreBytesLimit,
+			e.extstoreIOQueueDepth,
+		},
+		CollectorProcess: e.process.all(),
+		CollectorCgroup:  e.cgroup.all(),
+	}
+}
+
+// filterMetrics returns the metrics that belong to the collectors of set, or
+// to no collector at all.
+func (e *Exporter) filterMetrics(metrics []prometheus.Metric, set collectorSet) []prometheus.Metric {
+	var filtered []prometheus.Metric
+	for _, m := range metrics {
+		if name, ok := e.descCollectors[m.Desc()]; !ok || set[name] {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestCollectors(t *testing.T) {
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+
+		server := memcachedtest.NewServer(t, memcachedtest.Stats())
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithCollectors(CollectorSettings))
+
+		expected := `
+# HELP memcached_max_connections Maximum number of clients allowed.
+# TYPE memcached_max_connections gauge
+memcached_max_connections{server="SERVER"} 1024
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{server="SERVER"} 1
+`
+		expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected)); err != nil {
+			t.Error(err)
+		}
+		if commands, want := server.Commands(), []string{"stats settings"}; !reflect.DeepEqual(commands, want) {
+			t.Errorf("got commands %q, want %q", commands, want)
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		t.Parallel()
+
+		server := memcachedtest.NewServer(t, memcachedtest.Stats())
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithCollectors(CollectorGeneral, CollectorSlabs))
+		filtered, err := e.Filter([]string{CollectorSlabs})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := `
+# HELP memcached_malloced_bytes Number of bytes of memory allocated to slab pages.
+# TYPE memcached_malloced_bytes gauge
+memcached_malloced_bytes{server="SERVER"} 1.048576e+06
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{server="SERVER"} 1
+`
+		expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+		names := []string{"memcached_malloced_bytes", "memcached_up", "memcached_current_connections"}
+		if err := testutil.CollectAndCompare(filtered, strings.NewReader(expected), names...); err != nil {
+			t.Error(err)
+		}
+		if commands, want := server.Commands(), []string{"stats slabs"}; !reflect.DeepEqual(commands, want) {
+			t.Errorf("got commands %q, want %q", commands, want)
+		}
+
+		if _, err := e.Filter([]string{CollectorItems}); err == nil {
+			t.Error("expected error filtering a disabled collector")
+		}
+		if _, err := e.Filter([]string{"unknown"}); err == nil {
+			t.Error("expected error filtering an unknown collector")
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+
+		server := memcachedtest.NewServer(t, memcachedtest.Stats())
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithPolling(time.Hour))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go e.Poll(ctx)
+		for testutil.CollectAndCount(e, "memcached_last_collect_timestamp_seconds") == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		filtered, err := e.Filter([]string{CollectorGeneral})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := testutil.CollectAndCount(filtered, "memcached_current_connections"); n != 1 {
+			t.Errorf("got %d general metrics, want 1", n)
+		}
+		if n := testutil.CollectAndCount(filtered, "memcached_slab_chunk_size_bytes", "memcached_max_connections"); n != 0 {
+			t.Errorf("got %d metrics of other collectors, want 0", n)
+		}
+	})
+}
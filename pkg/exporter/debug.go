@@ -0,0 +1,176 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"net"
+	"strings"
+
+	"github.com/grobie/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Statuses of the keys of the stats.
+const (
+	// KeyMapped is the status of a key whose value is exported as a metric.
+	KeyMapped = "mapped"
+	// KeySkipped is the status of a key that is not exported.
+	KeySkipped = "skipped"
+	// KeyFailed is the status of a key whose value could not be parsed.
+	KeyFailed = "failed"
+)
+
+// StatsValue is the raw value of a key of a stats command, with its status.
+type StatsValue struct {
+	Value  string `json:"value"`
+	Status string `json:"status"`
+}
+
+// ServerStats is the raw output of the stats commands issued to a server.
+type ServerStats struct {
+	Server string `json:"server"`
+	Error  string `json:"error,omitempty"`
+	// Commands holds the keys of each command, e.g. "stats slabs".
+	Commands map[string]map[string]StatsValue `json:"commands,omitempty"`
+}
+
+// keyResults collects the result of parsing each key of the stats of a server,
+// keyed like in the output of the stats commands, e.g. "1:chunk_size" for slab
+// class 1.
+type keyResults struct {
+	keys map[string]*keyResult
+}
+
+type keyResult struct {
+	failed bool
+	// metrics are the metrics parsed from the key, before the slab options
+	// are applied.
+	metrics []prometheus.Metric
+}
+
+func newKeyResults() *keyResults {
+	return &keyResults{keys: map[string]*keyResult{}}
+}
+
+// add records that key failed to parse, or else was parsed into m.
+func (r *keyResults) add(key string, err error, m prometheus.Metric) {
+	result := r.keys[key]
+	if result == nil {
+		result = &keyResult{}
+		r.keys[key] = result
+	}
+	if err != nil {
+		result.failed = true
+	} else {
+		result.metrics = append(result.metrics, m)
+	}
+}
+
+// status returns the status of key, which is only mapped if one of the metrics
+// parsed from it is kept by the slab filter f.
+func (e *Exporter) status(r *keyResults, f *slabFilter, key string) string {
+	result := r.keys[key]
+	switch {
+	case result == nil:
+		return KeySkipped
+	case result.failed:
+		// A key that failed to parse once stays failed.
+		return KeyFailed
+	}
+	for _, m := range result.metrics {
+		if _, ok := e.bucketDescs[m.Desc()]; !ok || f == nil {
+			return KeyMapped
+		}
+		if _, _, ok := e.sample(f, m); ok {
+			return KeyMapped
+		}
+	}
+	return KeySkipped
+}
+
+// DebugStats queries the stats commands the collectors issue to each server,
+// and reports for each key whether it is exported as a metric.
+func (e *Exporter) DebugStats() []ServerStats {
+	addresses := e.Addresses()
+	servers := make([]ServerStats, 0, len(addresses))
+	for _, server := range addresses {
+		servers = append(servers, e.debugServer(server))
+	}
+	return servers
+}
+
+func (e *Exporter) debugServer(server string) ServerStats {
+	debug := ServerStats{Server: server}
+	c, err := e.connPool.Get(server)
+	if err != nil {
+		debug.Error = err.Error()
+		return debug
+	}
+	commands := e.statsCommands(server, e.collectors)
+	if e.collectors[CollectorSettings] {
+		commands = append(commands, "settings")
+	}
+	raw := map[string]map[string]string{}
+	for _, args := range commands {
+		var s map[string]string
+		if s, err = e.command(c, args); err != nil {
+			break
+		}
+		raw[args] = s
+	}
+	e.connPool.Put(c, err)
+	if err != nil {
+		debug.Error = err.Error()
+		return debug
+	}
+
+	stats := newStats()
+	for args, s := range raw {
+		if args == "settings" {
+			continue
+		}
+		if err := groupStats(stats, s); err != nil {
+			debug.Error = err.Error()
+			return debug
+		}
+	}
+	results, settingsResults := newKeyResults(), newKeyResults()
+	discard := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range discard {
+		}
+		close(done)
+	}()
+	parsed := map[net.Addr]memcache.Stats{c.RemoteAddr(): stats}
+	e.parseStats(discard, parsed, server, e.collectors, results)
+	e.parseStatsSettings(discard, map[net.Addr]map[string]string{c.RemoteAddr(): raw["settings"]}, server, settingsResults)
+	close(discard)
+	<-done
+
+	filter := e.newSlabFilter(server, parsed)
+	debug.Commands = map[string]map[string]StatsValue{}
+	for args, s := range raw {
+		r := results
+		if args == "settings" {
+			r = settingsResults
+		}
+		keys := map[string]StatsValue{}
+		for key, value := range s {
+			keys[key] = StatsValue{Value: value, Status: e.status(r, filter, key)}
+		}
+		debug.Commands[strings.TrimSpace("stats "+args)] = keys
+	}
+	return debug
+}
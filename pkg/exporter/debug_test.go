@@ -0,0 +1,101 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestDebugStats(t *testing.T) {
+	stats := memcachedtest.Stats()
+	stats[""]["pid"] = "42"
+	stats[""]["rusage_user"] = "bogus"
+	stats["settings"]["evictions"] = "on"
+	server := memcachedtest.NewServer(t, stats)
+
+	e := New(server.Addr+",127.0.0.1:1", time.Second, log.NewNopLogger(), nil)
+	debug := e.DebugStats()
+	if len(debug) != 2 {
+		t.Fatalf("want 2 servers, got %d", len(debug))
+	}
+	if debug[0].Error != "" {
+		t.Fatalf("unexpected error: %s", debug[0].Error)
+	}
+	for _, tc := range []struct {
+		command, key, value, status string
+	}{
+		{"stats", "uptime", "3600", KeyMapped},
+		{"stats", "cmd_set", "2", KeyMapped},
+		{"stats", "cas_badval", "0", KeyMapped},
+		{"stats", "pid", "42", KeySkipped},
+		{"stats", "rusage_user", "bogus", KeyFailed},
+		{"stats slabs", "1:chunk_size", "96", KeyMapped},
+		{"stats slabs", "total_malloced", "1048576", KeyMapped},
+		{"stats slabs", "active_slabs", "1", KeySkipped},
+		{"stats items", "items:1:number", "3", KeyMapped},
+		{"stats settings", "maxconns", "1024", KeyMapped},
+		{"stats settings", "evictions", "on", KeySkipped},
+	} {
+		got, ok := debug[0].Commands[tc.command][tc.key]
+		if !ok {
+			t.Errorf("%s: missing key %s", tc.command, tc.key)
+			continue
+		}
+		if want := (StatsValue{Value: tc.value, Status: tc.status}); got != want {
+			t.Errorf("%s: want %s to be %+v, got %+v", tc.command, tc.key, want, got)
+		}
+	}
+
+	if debug[1].Server != "127.0.0.1:1" || debug[1].Error == "" {
+		t.Errorf("want error for server that is down, got %+v", debug[1])
+	}
+}
+
+func TestDebugStatsSlabOptions(t *testing.T) {
+	stats := memcachedtest.Stats()
+	stats["slabs"]["2:chunk_size"] = "120"
+	stats["slabs"]["2:cmd_set"] = "1"
+	stats["slabs"]["2:cas_hits"] = "0"
+	stats["slabs"]["2:cas_badval"] = "0"
+	stats["items"]["items:2:number"] = "1"
+	server := memcachedtest.NewServer(t, stats)
+
+	// Keys whose series are removed by the slab options are not mapped.
+	e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithModule("small", config.ModuleConfig{
+		Slabs: &config.SlabsConfig{Drop: []string{"memcached_slab_chunk_size_bytes"}, MaxClasses: 1},
+	}))
+	debug := e.DebugStats()
+	if len(debug) != 1 || debug[0].Error != "" {
+		t.Fatalf("unexpected debug stats: %+v", debug)
+	}
+	for _, tc := range []struct {
+		command, key, status string
+	}{
+		{"stats slabs", "1:chunk_size", KeySkipped},
+		{"stats slabs", "1:cmd_set", KeyMapped},
+		{"stats slabs", "2:cmd_set", KeySkipped},
+		{"stats slabs", "total_malloced", KeyMapped},
+		{"stats items", "items:1:number", KeyMapped},
+		{"stats items", "items:2:number", KeySkipped},
+	} {
+		if got := debug[0].Commands[tc.command][tc.key].Status; got != tc.status {
+			t.Errorf("%s: want %s to be %s, got %s", tc.command, tc.key, tc.status, got)
+		}
+	}
+}
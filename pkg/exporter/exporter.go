@@ -16,6 +16,8 @@ package exporter
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"math"
 	"net"
 	"path/filepath"
 	"strconv"
@@ -27,12 +29,18 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/grobie/gomemcache/memcache"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/connpool"
 )
 
 const (
 	Namespace           = "memcached"
 	subsystemLruCrawler = "lru_crawler"
 	subsystemSlab       = "slab"
+	subsystemPool       = "pool"
+	subsystemCluster    = "cluster"
 )
 
 var errKeyNotFound = errors.New("key not found")
@@ -44,8 +52,46 @@ type Exporter struct {
 	logger    log.Logger
 	tlsConfig *tls.Config
 
+	connPool              *connpool.Pool
+	excludeOwnConnections bool
+	observer              Observer
+	cache                 *Cache
+	pollInterval          time.Duration
+	commandIntervals      map[string]time.Duration
+	maxConcurrency        int
+	collectors            collectorSet
+	collectorDescs        map[string][]*prometheus.Desc
+	descCollectors        map[*prometheus.Desc]string
+	module                string
+	moduleConfig          config.ModuleConfig
+	specs                 map[*prometheus.Desc]descSpec
+	bucketDescs           map[*prometheus.Desc]*prometheus.Desc
+	procFS                string
+	cgroupFS              string
+	defaultPIDFile        string
+
+	targetConfigs []config.TargetConfig
+	targets       []target
+	poolConfigs   []config.PoolConfig
+	pools         []pool
+
+	mutex        sync.Mutex
+	lastGets     map[poolServer]float64
+	lastCounters map[poolServer]map[string]float64
+	lastCommands map[string]map[string]commandResult
+	snapshots    map[string]*cacheEntry
+	starts       map[string]*serverStart
+	settings     map[string]map[string]string
+	answered     map[string]time.Time
+	pids         map[string]int
+
+	// addressesChanged is signalled when the addresses are replaced.
+	addressesChanged chan struct{}
+
 	up                       *prometheus.Desc
 	uptime                   *prometheus.Desc
+	startTime                *prometheus.Desc
+	restarts                 *prometheus.Desc
 	time                     *prometheus.Desc
 	version                  *prometheus.Desc
 	rusageUser               *prometheus.Desc
@@ -54,6 +100,7 @@ type Exporter struct {
 	bytesWritten             *prometheus.Desc
 	currentConnections       *prometheus.Desc
 	maxConnections           *prometheus.Desc
+	settingsDrift            *prometheus.Desc
 	connectionsTotal         *prometheus.Desc
 	rejectedConnections      *prometheus.Desc
 	connsYieldedTotal        *prometheus.Desc
@@ -130,17 +177,91 @@ type Exporter struct {
 	extstoreBytesFragmented  *prometheus.Desc
 	extstoreIOQueueDepth     *prometheus.Desc
 	acceptingConnections     *prometheus.Desc
+	poolExpectedShare        *prometheus.Desc
+	poolImbalance            *prometheus.Desc
+	clusterMembers           *prometheus.Desc
+	clusterMembersUp         *prometheus.Desc
+	cacheAge                 *prometheus.Desc
+	lastCollect              *prometheus.Desc
+	process                  processDescs
+	cgroup                   cgroupDescs
+	clusterGauges            []clusterGauge
+	clusterCounters          []clusterCounter
+}
+
+// Option configures an Exporter.
+type Option func(*Exporter)
+
+// WithTargets sets the target configurations from which the alias and the
+// additional labels of each server are derived.
+func WithTargets(targets []config.TargetConfig) Option {
+	return func(e *Exporter) {
+		e.targetConfigs = targets
+	}
+}
+
+// WithPools sets the client pools whose key distribution is analysed. A pool
+// is only analysed if all of its servers are among the exporter's addresses.
+func WithPools(pools []config.PoolConfig) Option {
+	return func(e *Exporter) {
+		e.poolConfigs = pools
+	}
+}
+
+// WithConnPool sets the pool from which connections to the servers are
+// obtained. By default, the exporter opens new connections on every scrape.
+func WithConnPool(p *connpool.Pool) Option {
+	return func(e *Exporter) {
+		e.connPool = p
+	}
 }
 
-// New returns an initialized exporter.
-func New(server string, timeout time.Duration, logger log.Logger, tlsConfig *tls.Config) *Exporter {
+// WithExcludeOwnConnections sets whether the connections of the exporter are
+// subtracted from the reported connection metrics.
+func WithExcludeOwnConnections(exclude bool) Option {
+	return func(e *Exporter) {
+		e.excludeOwnConnections = exclude
+	}
+}
+
+// WithCache sets the cache from which the results of collecting a server are
+// served, instead of querying the server on every collection.
+func WithCache(c *Cache) Option {
+	return func(e *Exporter) {
+		e.cache = c
+	}
+}
+
+// WithMaxConcurrency bounds the number of servers collected at the same time.
+// Zero means no limit.
+func WithMaxConcurrency(n int) Option {
+	return func(e *Exporter) {
+		e.maxConcurrency = n
+	}
+}
+
+// WithObserver sets the observer notified about collections.
+func WithObserver(o Observer) Option {
+	return func(e *Exporter) {
+		e.observer = o
+	}
+}
+
+// ParseAddresses returns the addresses of server, a comma-separated list of
+// addresses where unix socket paths may be glob patterns. Malformed patterns
+// are skipped, and the first of them is returned as error.
+func ParseAddresses(server string) ([]string, error) {
 	var addresses []string
+	var firstErr error
 	for _, address := range strings.Split(server, ",") {
 		if 0 < len(address) {
 			if address[0] == '/' && strings.IndexByte(address, '*') != -1 {
 				matches, err := filepath.Glob(address)
 				if err != nil {
-					panic(err)
+					if firstErr == nil {
+						firstErr = fmt.Errorf("invalid address pattern %q: %w", address, err)
+					}
+					continue
 				}
 				addresses = append(addresses, matches...)
 			} else {
@@ -148,529 +269,686 @@ func New(server string, timeout time.Duration, logger log.Logger, tlsConfig *tls
 			}
 		}
 	}
+	return addresses, firstErr
+}
+
+// New returns an initialized exporter. Malformed address patterns of server
+// are logged and skipped, see ParseAddresses.
+func New(server string, timeout time.Duration, logger log.Logger, tlsConfig *tls.Config, opts ...Option) *Exporter {
+	addresses, err := ParseAddresses(server)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error parsing memcached address", "err", err)
+	}
+
+	specs := map[*prometheus.Desc]descSpec{}
+	newDesc := func(fqName, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+		desc := prometheus.NewDesc(fqName, help, variableLabels, constLabels)
+		specs[desc] = descSpec{fqName: fqName, help: help, variableLabels: variableLabels, constLabels: constLabels}
+		return desc
+	}
 
-	return &Exporter{
-		addresses: addresses,
-		timeout:   timeout,
-		logger:    logger,
-		tlsConfig: tlsConfig,
-		up: prometheus.NewDesc(
+	e := &Exporter{
+		addresses:    addresses,
+		timeout:      timeout,
+		logger:       logger,
+		tlsConfig:    tlsConfig,
+		observer:     nopObserver{},
+		procFS:       procfs.DefaultMountPoint,
+		cgroupFS:     defaultCgroupFS,
+		collectors:   newCollectorSet(Collectors...),
+		lastGets:     map[poolServer]float64{},
+		lastCounters: map[poolServer]map[string]float64{},
+		lastCommands: map[string]map[string]commandResult{},
+		starts:       map[string]*serverStart{},
+		settings:     map[string]map[string]string{},
+		answered:     map[string]time.Time{},
+
+		addressesChanged: make(chan struct{}, 1),
+		up: newDesc(
 			prometheus.BuildFQName(Namespace, "", "up"),
 			"Could the memcached server be reached.",
 			[]string{"server"},
 			nil,
 		),
-		uptime: prometheus.NewDesc(
+		uptime: newDesc(
 			prometheus.BuildFQName(Namespace, "", "uptime_seconds"),
 			"Number of seconds since the server started.",
 			[]string{"server"},
 			nil,
 		),
-		time: prometheus.NewDesc(
+		startTime: newDesc(
+			prometheus.BuildFQName(Namespace, "", "start_time_seconds"),
+			"UNIX time at which the server started, computed from its time and uptime.",
+			[]string{"server"},
+			nil,
+		),
+		restarts: newDesc(
+			prometheus.BuildFQName(Namespace, "", "restarts_total"),
+			"Number of restarts of the server observed by the exporter, by type of restart.",
+			[]string{"type", "server"},
+			nil,
+		),
+		time: newDesc(
 			prometheus.BuildFQName(Namespace, "", "time_seconds"),
 			"current UNIX time according to the server.",
 			[]string{"server"},
 			nil,
 		),
-		version: prometheus.NewDesc(
+		version: newDesc(
 			prometheus.BuildFQName(Namespace, "", "version"),
 			"The version of this memcached server.",
 			[]string{"version", "server"},
 			nil,
 		),
-		rusageUser: prometheus.NewDesc(
+		rusageUser: newDesc(
 			prometheus.BuildFQName(Namespace, "", "process_user_cpu_seconds_total"),
 			"Accumulated user time for this process.",
 			[]string{"server"},
 			nil,
 		),
-		rusageSystem: prometheus.NewDesc(
+		rusageSystem: newDesc(
 			prometheus.BuildFQName(Namespace, "", "process_system_cpu_seconds_total"),
 			"Accumulated system time for this process.",
 			[]string{"server"},
 			nil,
 		),
-		bytesRead: prometheus.NewDesc(
+		bytesRead: newDesc(
 			prometheus.BuildFQName(Namespace, "", "read_bytes_total"),
 			"Total number of bytes read by this server from network.",
 			[]string{"server"},
 			nil,
 		),
-		bytesWritten: prometheus.NewDesc(
+		bytesWritten: newDesc(
 			prometheus.BuildFQName(Namespace, "", "written_bytes_total"),
 			"Total number of bytes sent by this server to network.",
 			[]string{"server"},
 			nil,
 		),
-		currentConnections: prometheus.NewDesc(
+		currentConnections: newDesc(
 			prometheus.BuildFQName(Namespace, "", "current_connections"),
 			"Current number of open connections.",
 			[]string{"server"},
 			nil,
 		),
-		maxConnections: prometheus.NewDesc(
+		maxConnections: newDesc(
 			prometheus.BuildFQName(Namespace, "", "max_connections"),
 			"Maximum number of clients allowed.",
 			[]string{"server"},
 			nil,
 		),
-		connectionsTotal: prometheus.NewDesc(
+		settingsDrift: newDesc(
+			prometheus.BuildFQName(Namespace, "", "settings_drift"),
+			"Whether the setting differs between the servers of the exporter.",
+			[]string{"setting"},
+			nil,
+		),
+		connectionsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, "", "connections_total"),
 			"Total number of connections opened since the server started running.",
 			[]string{"server"},
 			nil,
 		),
-		rejectedConnections: prometheus.NewDesc(
+		rejectedConnections: newDesc(
 			prometheus.BuildFQName(Namespace, "", "connections_rejected_total"),
 			"Total number of connections rejected due to hitting the memcached's -c limit in maxconns_fast mode.",
 			[]string{"server"},
 			nil,
 		),
-		connsYieldedTotal: prometheus.NewDesc(
+		connsYieldedTotal: newDesc(
 			prometheus.BuildFQName(Namespace, "", "connections_yielded_total"),
 			"Total number of connections yielded running due to hitting the memcached's -R limit.",
 			[]string{"server"},
 			nil,
 		),
-		listenerDisabledTotal: prometheus.NewDesc(
+		listenerDisabledTotal: newDesc(
 			prometheus.BuildFQName(Namespace, "", "connections_listener_disabled_total"),
 			"Number of times that memcached has hit its connections limit and disabled its listener.",
 			[]string{"server"},
 			nil,
 		),
-		currentBytes: prometheus.NewDesc(
+		currentBytes: newDesc(
 			prometheus.BuildFQName(Namespace, "", "current_bytes"),
 			"Current number of bytes used to store items.",
 			[]string{"server"},
 			nil,
 		),
-		limitBytes: prometheus.NewDesc(
+		limitBytes: newDesc(
 			prometheus.BuildFQName(Namespace, "", "limit_bytes"),
 			"Number of bytes this server is allowed to use for storage.",
 			[]string{"server"},
 			nil,
 		),
-		commands: prometheus.NewDesc(
+		commands: newDesc(
 			prometheus.BuildFQName(Namespace, "", "commands_total"),
 			"Total number of all requests broken down by command (get, set, etc.) and status.",
 			[]string{"command", "status", "server"},
 			nil,
 		),
-		items: prometheus.NewDesc(
+		items: newDesc(
 			prometheus.BuildFQName(Namespace, "", "current_items"),
 			"Current number of items stored by this instance.",
 			[]string{"server"},
 			nil,
 		),
-		itemsTotal: prometheus.NewDesc(
+		itemsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, "", "items_total"),
 			"Total number of items stored during the life of this instance.",
 			[]string{"server"},
 			nil,
 		),
-		evictions: prometheus.NewDesc(
+		evictions: newDesc(
 			prometheus.BuildFQName(Namespace, "", "items_evicted_total"),
 			"Total number of valid items removed from cache to free memory for new items.",
 			[]string{"server"},
 			nil,
 		),
-		reclaimed: prometheus.NewDesc(
+		reclaimed: newDesc(
 			prometheus.BuildFQName(Namespace, "", "items_reclaimed_total"),
 			"Total number of times an entry was stored using memory from an expired entry.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerEnabled: prometheus.NewDesc(
+		lruCrawlerEnabled: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "enabled"),
 			"Whether the LRU crawler is enabled.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerSleep: prometheus.NewDesc(
+		lruCrawlerSleep: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "sleep"),
 			"Microseconds to sleep between LRU crawls.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerMaxItems: prometheus.NewDesc(
+		lruCrawlerMaxItems: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "to_crawl"),
 			"Max items to crawl per slab per run.",
 			[]string{"server"},
 			nil,
 		),
-		lruMaintainerThread: prometheus.NewDesc(
+		lruMaintainerThread: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "maintainer_thread"),
 			"Split LRU mode and background threads.",
 			[]string{"server"},
 			nil,
 		),
-		lruHotPercent: prometheus.NewDesc(
+		lruHotPercent: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "hot_percent"),
 			"Percent of slab memory reserved for HOT LRU.",
 			[]string{"server"},
 			nil,
 		),
-		lruWarmPercent: prometheus.NewDesc(
+		lruWarmPercent: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "warm_percent"),
 			"Percent of slab memory reserved for WARM LRU.",
 			[]string{"server"},
 			nil,
 		),
-		lruHotMaxAgeFactor: prometheus.NewDesc(
+		lruHotMaxAgeFactor: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "hot_max_factor"),
 			"Set idle age of HOT LRU to COLD age * this",
 			[]string{"server"},
 			nil,
 		),
-		lruWarmMaxAgeFactor: prometheus.NewDesc(
+		lruWarmMaxAgeFactor: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "warm_max_factor"),
 			"Set idle age of WARM LRU to COLD age * this",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerStarts: prometheus.NewDesc(
+		lruCrawlerStarts: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "starts_total"),
 			"Times an LRU crawler was started.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerReclaimed: prometheus.NewDesc(
+		lruCrawlerReclaimed: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "reclaimed_total"),
 			"Total items freed by LRU Crawler.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerItemsChecked: prometheus.NewDesc(
+		lruCrawlerItemsChecked: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "items_checked_total"),
 			"Total items examined by LRU Crawler.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerMovesToCold: prometheus.NewDesc(
+		lruCrawlerMovesToCold: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "moves_to_cold_total"),
 			"Total number of items moved from HOT/WARM to COLD LRU's.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerMovesToWarm: prometheus.NewDesc(
+		lruCrawlerMovesToWarm: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "moves_to_warm_total"),
 			"Total number of items moved from COLD to WARM LRU.",
 			[]string{"server"},
 			nil,
 		),
-		lruCrawlerMovesWithinLru: prometheus.NewDesc(
+		lruCrawlerMovesWithinLru: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemLruCrawler, "moves_within_lru_total"),
 			"Total number of items reshuffled within HOT or WARM LRU's.",
 			[]string{"server"},
 			nil,
 		),
-		malloced: prometheus.NewDesc(
+		malloced: newDesc(
 			prometheus.BuildFQName(Namespace, "", "malloced_bytes"),
 			"Number of bytes of memory allocated to slab pages.",
 			[]string{"server"},
 			nil,
 		),
-		itemsNumber: prometheus.NewDesc(
+		itemsNumber: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "current_items"),
 			"Number of items currently stored in this slab class.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsAge: prometheus.NewDesc(
+		itemsAge: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_age_seconds"),
 			"Number of seconds the oldest item has been in the slab class.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsCrawlerReclaimed: prometheus.NewDesc(
+		itemsCrawlerReclaimed: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_crawler_reclaimed_total"),
 			"Number of items freed by the LRU Crawler.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsEvicted: prometheus.NewDesc(
+		itemsEvicted: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_evicted_total"),
 			"Total number of times an item had to be evicted from the LRU before it expired.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsEvictedNonzero: prometheus.NewDesc(
+		itemsEvictedNonzero: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_evicted_nonzero_total"),
 			"Total number of times an item which had an explicit expire time set had to be evicted from the LRU before it expired.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsEvictedTime: prometheus.NewDesc(
+		itemsEvictedTime: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_evicted_time_seconds"),
 			"Seconds since the last access for the most recent item evicted from this class.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsEvictedUnfetched: prometheus.NewDesc(
+		itemsEvictedUnfetched: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_evicted_unfetched_total"),
 			"Total nmber of items evicted and never fetched.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsExpiredUnfetched: prometheus.NewDesc(
+		itemsExpiredUnfetched: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_expired_unfetched_total"),
 			"Total number of valid items evicted from the LRU which were never touched after being set.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsOutofmemory: prometheus.NewDesc(
+		itemsOutofmemory: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_outofmemory_total"),
 			"Total number of items for this slab class that have triggered an out of memory error.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsReclaimed: prometheus.NewDesc(
+		itemsReclaimed: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_reclaimed_total"),
 			"Total number of items reclaimed.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsTailrepairs: prometheus.NewDesc(
+		itemsTailrepairs: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_tailrepairs_total"),
 			"Total number of times the entries for a particular ID need repairing.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsMovesToCold: prometheus.NewDesc(
+		itemsMovesToCold: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_moves_to_cold"),
 			"Number of items moved from HOT or WARM into COLD.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsMovesToWarm: prometheus.NewDesc(
+		itemsMovesToWarm: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_moves_to_warm"),
 			"Number of items moves from COLD into WARM.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsMovesWithinLru: prometheus.NewDesc(
+		itemsMovesWithinLru: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "items_moves_within_lru"),
 			"Number of times active items were bumped within HOT or WARM.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsHot: prometheus.NewDesc(
+		itemsHot: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "hot_items"),
 			"Number of items presently stored in the HOT LRU.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsWarm: prometheus.NewDesc(
+		itemsWarm: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "warm_items"),
 			"Number of items presently stored in the WARM LRU.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsCold: prometheus.NewDesc(
+		itemsCold: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "cold_items"),
 			"Number of items presently stored in the COLD LRU.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsTemporary: prometheus.NewDesc(
+		itemsTemporary: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "temporary_items"),
 			"Number of items presently stored in the TEMPORARY LRU.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsAgeOldestHot: prometheus.NewDesc(
+		itemsAgeOldestHot: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "hot_age_seconds"),
 			"Age of the oldest item in HOT LRU.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsAgeOldestWarm: prometheus.NewDesc(
+		itemsAgeOldestWarm: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "warm_age_seconds"),
 			"Age of the oldest item in HOT LRU.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		itemsLruHits: prometheus.NewDesc(
+		itemsLruHits: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "lru_hits_total"),
 			"Number of get_hits to the LRU.",
 			[]string{"slab", "lru", "server"},
 			nil,
 		),
-		slabsChunkSize: prometheus.NewDesc(
+		slabsChunkSize: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "chunk_size_bytes"),
 			"Number of bytes allocated to each chunk within this slab class.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsChunksPerPage: prometheus.NewDesc(
+		slabsChunksPerPage: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "chunks_per_page"),
 			"Number of chunks within a single page for this slab class.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsCurrentPages: prometheus.NewDesc(
+		slabsCurrentPages: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "current_pages"),
 			"Number of pages allocated to this slab class.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsCurrentChunks: prometheus.NewDesc(
+		slabsCurrentChunks: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "current_chunks"),
 			"Number of chunks allocated to this slab class.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsChunksUsed: prometheus.NewDesc(
+		slabsChunksUsed: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "chunks_used"),
 			"Number of chunks allocated to an item.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsChunksFree: prometheus.NewDesc(
+		slabsChunksFree: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "chunks_free"),
 			"Number of chunks not yet allocated items.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsChunksFreeEnd: prometheus.NewDesc(
+		slabsChunksFreeEnd: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "chunks_free_end"),
 			"Number of free chunks at the end of the last allocated page.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsMemRequested: prometheus.NewDesc(
+		slabsMemRequested: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "mem_requested_bytes"),
 			"Number of bytes of memory actual items take up within a slab.",
 			[]string{"slab", "server"},
 			nil,
 		),
-		slabsCommands: prometheus.NewDesc(
+		slabsCommands: newDesc(
 			prometheus.BuildFQName(Namespace, subsystemSlab, "commands_total"),
 			"Total number of all requests broken down by command (get, set, etc.) and status per slab.",
 			[]string{"slab", "command", "status", "server"},
 			nil,
 		),
-		extstoreCompactLost: prometheus.NewDesc(
+		extstoreCompactLost: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_compact_lost_total"),
 			"Total number of items lost because they were locked during extstore compaction.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreCompactRescues: prometheus.NewDesc(
+		extstoreCompactRescues: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_compact_rescued_total"),
 			"Total number of items moved to a new page during extstore compaction,",
 			[]string{"server"},
 			nil,
 		),
-		extstoreCompactSkipped: prometheus.NewDesc(
+		extstoreCompactSkipped: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_compact_skipped_total"),
 			"Total number of items dropped due to inactivity during extstore compaction.",
 			[]string{"server"},
 			nil,
 		),
-		extstorePageAllocs: prometheus.NewDesc(
+		extstorePageAllocs: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_pages_allocated_total"),
 			"Total number of times a page was allocated in extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstorePageEvictions: prometheus.NewDesc(
+		extstorePageEvictions: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_pages_evicted_total"),
 			"Total number of times a page was evicted from extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstorePageReclaims: prometheus.NewDesc(
+		extstorePageReclaims: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_pages_reclaimed_total"),
 			"Total number of times an empty extstore page was freed.",
 			[]string{"server"},
 			nil,
 		),
-		extstorePagesFree: prometheus.NewDesc(
+		extstorePagesFree: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_pages_free"),
 			"Number of extstore pages not yet containing any items.",
 			[]string{"server"},
 			nil,
 		),
-		extstorePagesUsed: prometheus.NewDesc(
+		extstorePagesUsed: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_pages_used"),
 			"Number of extstore pages containing at least one item.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreObjectsEvicted: prometheus.NewDesc(
+		extstoreObjectsEvicted: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_objects_evicted_total"),
 			"Total number of items evicted from extstore to free up space.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreObjectsRead: prometheus.NewDesc(
+		extstoreObjectsRead: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_objects_read_total"),
 			"Total number of items read from extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreObjectsWritten: prometheus.NewDesc(
+		extstoreObjectsWritten: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_objects_written_total"),
 			"Total number of items written to extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreObjectsUsed: prometheus.NewDesc(
+		extstoreObjectsUsed: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_objects_used"),
 			"Number of items stored in extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreBytesEvicted: prometheus.NewDesc(
+		extstoreBytesEvicted: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_bytes_evicted_total"),
 			"Total number of bytes evicted from extstore to free up space.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreBytesWritten: prometheus.NewDesc(
+		extstoreBytesWritten: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_bytes_written_total"),
 			"Total number of bytes written to extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreBytesRead: prometheus.NewDesc(
+		extstoreBytesRead: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_bytes_read_total"),
 			"Total number of bytes read from extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreBytesUsed: prometheus.NewDesc(
+		extstoreBytesUsed: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_bytes_used"),
 			"Current number of bytes used to store items in extstore.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreBytesFragmented: prometheus.NewDesc(
+		extstoreBytesFragmented: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_bytes_fragmented"),
 			"Current number of bytes in extstore pages allocated but not used to store an object.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreBytesLimit: prometheus.NewDesc(
+		extstoreBytesLimit: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_bytes_limit"),
 			"Number of bytes of external storage allocated for this server.",
 			[]string{"server"},
 			nil,
 		),
-		extstoreIOQueueDepth: prometheus.NewDesc(
+		extstoreIOQueueDepth: newDesc(
 			prometheus.BuildFQName(Namespace, "", "extstore_io_queue_depth"),
 			"Number of items in the I/O queue waiting to be processed.",
 			[]string{"server"},
 			nil,
 		),
-		acceptingConnections: prometheus.NewDesc(
+		acceptingConnections: newDesc(
 			prometheus.BuildFQName(Namespace, "", "accepting_connections"),
 			"The Memcached server is currently accepting new connections.",
 			[]string{"server"},
 			nil,
 		),
+		poolExpectedShare: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemPool, "expected_share"),
+			"Fraction of the keyspace the client pool is expected to assign to the server.",
+			[]string{"pool", "server"},
+			nil,
+		),
+		poolImbalance: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemPool, "imbalance_ratio"),
+			"Observed share of the pool's items, bytes or gets on the server divided by its expected share.",
+			[]string{"pool", "server", "stat"},
+			nil,
+		),
+		clusterMembers: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, "members"),
+			"Number of servers in the pool.",
+			[]string{"pool"},
+			nil,
+		),
+		clusterMembersUp: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, "members_up"),
+			"Number of servers in the pool that could be collected.",
+			[]string{"pool"},
+			nil,
+		),
+		lastCollect: newDesc(
+			prometheus.BuildFQName(Namespace, "", "last_collect_timestamp_seconds"),
+			"Time of the last collection of the server, in seconds since the epoch.",
+			[]string{"server"},
+			nil,
+		),
+		cacheAge: newDesc(
+			prometheus.BuildFQName(Namespace, "exporter", "cache_age_seconds"),
+			"Number of seconds since the cached samples of the server were collected.",
+			[]string{"server"},
+			nil,
+		),
+	}
+	e.process = newProcessDescs(newDesc)
+	e.cgroup = newCgroupDescs(newDesc)
+	e.clusterGauges = []clusterGauge{
+		newClusterGauge(newDesc, "curr_items", "current_items", "Current number of items stored."),
+		newClusterGauge(newDesc, "bytes", "current_bytes", "Current number of bytes used to store items."),
+		newClusterGauge(newDesc, "limit_maxbytes", "limit_bytes", "Number of bytes the servers are allowed to use for storage."),
+		newClusterGauge(newDesc, "curr_connections", "current_connections", "Current number of open connections."),
+	}
+	clusterCommands := newDesc(
+		prometheus.BuildFQName(Namespace, subsystemCluster, "commands_total"),
+		"Total number of requests broken down by command and status, summed up across the members of the pool.",
+		[]string{"command", "status", "pool"},
+		nil,
+	)
+	e.clusterCounters = []clusterCounter{
+		{key: "get_hits", desc: clusterCommands, labelValues: []string{"get", "hit"}},
+		{key: "get_misses", desc: clusterCommands, labelValues: []string{"get", "miss"}},
+		{key: "evictions", desc: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, "items_evicted_total"),
+			"Total number of valid items removed from cache to free memory for new items, summed up across the members of the pool.",
+			[]string{"pool"},
+			nil,
+		)},
+		{key: "total_items", desc: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, "items_total"),
+			"Total number of items stored, summed up across the members of the pool.",
+			[]string{"pool"},
+			nil,
+		)},
+		{key: "bytes_read", desc: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, "read_bytes_total"),
+			"Total number of bytes read from network, summed up across the members of the pool.",
+			[]string{"pool"},
+			nil,
+		)},
+		{key: "bytes_written", desc: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, "written_bytes_total"),
+			"Total number of bytes sent to network, summed up across the members of the pool.",
+			[]string{"pool"},
+			nil,
+		)},
+		{key: "total_connections", desc: newDesc(
+			prometheus.BuildFQName(Namespace, subsystemCluster, "connections_total"),
+			"Total number of connections opened, summed up across the members of the pool.",
+			[]string{"pool"},
+			nil,
+		)},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.collectorDescs = e.newCollectorDescs()
+	e.descCollectors = map[*prometheus.Desc]string{}
+	for name, descs := range e.collectorDescs {
+		for _, desc := range descs {
+			e.descCollectors[desc] = name
+		}
+	}
+	e.bucketDescs = e.newBucketDescs(newDesc, specs)
+	e.specs = specs
+	if e.connPool == nil {
+		e.connPool = connpool.New(timeout, tlsConfig, 0)
+	}
+	for _, cfg := range e.targetConfigs {
+		e.targets = append(e.targets, newTarget(cfg, specs))
+	}
+	for _, cfg := range e.poolConfigs {
+		if !e.hasAddresses(cfg.Servers) {
+			level.Debug(logger).Log("msg", "Skipping pool with servers outside of the exporter's addresses", "pool", cfg.Name)
+			continue
+		}
+		e.pools = append(e.pools, newPool(cfg))
 	}
+	return e
 }
 
 // Describe describes all the metrics exported by the memcached exporter. It
@@ -678,6 +956,8 @@ func New(server string, timeout time.Duration, logger log.Logger, tlsConfig *tls
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.up
 	ch <- e.uptime
+	ch <- e.startTime
+	ch <- e.restarts
 	ch <- e.time
 	ch <- e.version
 	ch <- e.rusageUser
@@ -686,6 +966,7 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.bytesWritten
 	ch <- e.currentConnections
 	ch <- e.maxConnections
+	ch <- e.settingsDrift
 	ch <- e.connectionsTotal
 	ch <- e.rejectedConnections
 	ch <- e.connsYieldedTotal
@@ -763,57 +1044,411 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.extstoreBytesLimit
 	ch <- e.extstoreIOQueueDepth
 	ch <- e.acceptingConnections
+	ch <- e.poolExpectedShare
+	ch <- e.poolImbalance
+	ch <- e.clusterMembers
+	ch <- e.clusterMembersUp
+	if e.cache != nil {
+		ch <- e.cacheAge
+	}
+	if e.snapshots != nil {
+		ch <- e.lastCollect
+	}
+	for _, desc := range e.process.all() {
+		ch <- desc
+	}
+	for _, desc := range e.cgroup.all() {
+		ch <- desc
+	}
+	for _, g := range e.clusterGauges {
+		ch <- g.sum
+		ch <- g.min
+		ch <- g.max
+	}
+	seen := map[*prometheus.Desc]bool{}
+	for _, c := range e.clusterCounters {
+		if !seen[c.desc] {
+			seen[c.desc] = true
+			ch <- c.desc
+		}
+	}
 }
 
 // Collect fetches the statistics from all configured memcached servers, and
 // delivers them as Prometheus metrics. It implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	var wg sync.WaitGroup
-	for _, address := range e.addresses {
+	e.collect(ch, e.collectors)
+}
+
+// collect works like Collect, but only collects the given collectors.
+func (e *Exporter) collect(ch chan<- prometheus.Metric, set collectorSet) {
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		addresses = e.Addresses()
+		stats     = make(map[string]map[string]string, len(addresses))
+	)
+	n := len(addresses)
+	if 0 < e.maxConcurrency && e.maxConcurrency < n {
+		n = e.maxConcurrency
+	}
+	sem := make(chan struct{}, n)
+	for _, address := range addresses {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func(server string) {
-			e.CollectServer(ch, server)
+			defer func() { <-sem }()
+			s := e.collectServer(ch, server, set)
+			mutex.Lock()
+			stats[server] = s
+			mutex.Unlock()
 			wg.Done()
 		}(address)
 	}
 	wg.Wait()
+
+	if set[CollectorSettings] && len(addresses) > 1 {
+		e.collectSettingsDrift(ch)
+	}
+
+	// The pools are analysed based on the general stats.
+	if !set[CollectorGeneral] {
+		return
+	}
+	for i := range e.pools {
+		e.collectPool(ch, &e.pools[i], stats)
+		if e.pools[i].Aggregate {
+			e.collectCluster(ch, &e.pools[i], stats)
+		}
+	}
 }
 
 // CollectServer fetches the statistics from the configured memcached server, and
 // delivers them as Prometheus metrics. It implements prometheus.Collector.
 func (e *Exporter) CollectServer(ch chan<- prometheus.Metric, server string) {
-	c, err := memcache.New(server)
+	e.collectServer(ch, server, e.collectors)
+}
+
+// collectServer works like CollectServer, and returns the general stats of the
+// server, or nil if it is down.
+func (e *Exporter) collectServer(ch chan<- prometheus.Metric, server string, set collectorSet) map[string]string {
+	if label := e.labeler(server); label != nil {
+		labeled := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func(ch chan<- prometheus.Metric) {
+			for m := range labeled {
+				ch <- label(m)
+			}
+			close(done)
+		}(ch)
+		defer func() {
+			close(labeled)
+			<-done
+		}()
+		ch = labeled
+	}
+
+	if set[CollectorProcess] {
+		e.collectProcess(ch, server)
+	}
+	var stats map[string]string
+	switch {
+	case e.snapshots != nil:
+		stats = e.collectSnapshot(ch, server, set)
+	case e.cache != nil:
+		stats = e.collectCached(ch, server, set)
+	default:
+		stats = e.fetchServer(ch, server, set)
+	}
+	// The sizing of the cgroup is based on the general stats.
+	if set[CollectorCgroup] {
+		e.collectCgroup(ch, server, stats)
+	}
+	return stats
+}
+
+// fetchServer queries server for its stats and delivers them as Prometheus
+// metrics. It returns the general stats of the server, or nil if it is down.
+func (e *Exporter) fetchServer(ch chan<- prometheus.Metric, server string, set collectorSet) map[string]string {
+	return e.emitServer(ch, server, set, e.queryServer(server, set), true)
+}
+
+// serverStats is the answer of a server to the stats commands of the
+// collectors of a set.
+type serverStats struct {
+	start    time.Time
+	stats    map[net.Addr]memcache.Stats
+	settings map[net.Addr]map[string]string
+	// connectErr is the error connecting to the server, and err the error of
+	// the stats commands.
+	connectErr error
+	err        error
+}
+
+// queryServer issues the stats commands of the collectors of set to server.
+func (e *Exporter) queryServer(server string, set collectorSet) *serverStats {
+	r := &serverStats{start: time.Now(), settings: map[net.Addr]map[string]string{}}
+	c, err := e.connPool.Get(server)
+	e.observer.ObserveCommand(server, "connect", time.Since(r.start), err)
 	if err != nil {
-		ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 0, server)
 		level.Error(e.logger).Log("msg", "Failed to connect to memcached", "err", err)
-		return
+		r.connectErr = err
+		return r
+	}
+
+	var statsErr error
+	r.stats, statsErr = e.stats(c, set)
+	if statsErr != nil {
+		level.Error(e.logger).Log("msg", "Failed to collect stats from memcached", "err", statsErr)
+	}
+	var settingsErr error
+	if set[CollectorSettings] {
+		var settings map[string]string
+		settings, settingsErr = e.command(c, "settings")
+		if settingsErr != nil {
+			level.Error(e.logger).Log("msg", "Could not query stats settings", "err", settingsErr)
+		} else {
+			r.settings[c.RemoteAddr()] = settings
+		}
+	}
+	r.err = firstError(statsErr, settingsErr)
+	e.connPool.Put(c, r.err)
+	return r
+}
+
+// emitServer delivers the stats r of server as Prometheus metrics, and returns
+// the general stats of the server, or nil if it is down. The collection is only
+// observed if r was just queried rather than served from a cache.
+func (e *Exporter) emitServer(ch chan<- prometheus.Metric, server string, set collectorSet, r *serverStats, queried bool) map[string]string {
+	if r.connectErr != nil {
+		ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 0, server)
+		if queried {
+			e.observer.ObserveCollect(server, time.Since(r.start), r.connectErr)
+		}
+		e.recordSettings(server, nil)
+		return nil
 	}
-	c.Timeout = e.timeout
-	c.TlsConfig = e.tlsConfig
 
+	var parseErr error
+	createdCh, flushCreated := ch, func() {}
+	if start, ok := e.observeStart(server, r.stats, r.settings); ok {
+		createdCh, flushCreated = withCreated(ch, start)
+	}
+	slabsCh, flushSlabs := e.filterSlabs(createdCh, server, r.stats)
+	if err := e.parseStats(slabsCh, r.stats, server, set, nil); err != nil {
+		parseErr = &ParseError{Err: err}
+	}
+	flushSlabs()
+	flushCreated()
+	if err := e.parseStatsSettings(ch, r.settings, server, nil); err != nil {
+		parseErr = &ParseError{Err: err}
+	}
+
+	err := firstError(r.err, parseErr)
 	up := float64(1)
-	stats, err := c.Stats()
 	if err != nil {
-		level.Error(e.logger).Log("msg", "Failed to collect stats from memcached", "err", err)
 		up = 0
 	}
-	statsSettings, err := c.StatsSettings()
+	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, up, server)
+	if queried {
+		e.observer.ObserveCollect(server, time.Since(r.start), err)
+	}
+
+	if up == 0 {
+		e.recordSettings(server, nil)
+		return nil
+	}
+	e.recordAnswer(server, r.start)
+	for addr, t := range r.stats {
+		if settings, ok := r.settings[addr]; ok {
+			e.recordSettings(server, withVersion(settings, t.Stats))
+		}
+		if set[CollectorGeneral] {
+			e.collectStart(ch, server)
+		}
+		return t.Stats
+	}
+	return nil
+}
+
+// stats issues the stats commands needed by the collectors of set on c, and
+// groups the results like memcache.Client.Stats does.
+func (e *Exporter) stats(c *connpool.Conn, set collectorSet) (map[net.Addr]memcache.Stats, error) {
+	stats := newStats()
+	for _, args := range e.statsCommands(c.Server(), set) {
+		s, err := e.command(c, args)
+		if err != nil {
+			return map[net.Addr]memcache.Stats{}, err
+		}
+		if err := groupStats(stats, s); err != nil {
+			return map[net.Addr]memcache.Stats{}, err
+		}
+	}
+
+	if e.excludeOwnConnections {
+		e.subtractOwnConnections(c.Server(), stats.Stats)
+	}
+	return map[net.Addr]memcache.Stats{c.RemoteAddr(): stats}, nil
+}
+
+// statsCommands returns the arguments of the stats commands needed by the
+// collectors of set on server.
+func (e *Exporter) statsCommands(server string, set collectorSet) []string {
+	var commands []string
+	// The sizing of the cgroup is based on limit_maxbytes and total_malloced.
+	if set[CollectorGeneral] || set[CollectorExtstore] || set[CollectorCgroup] {
+		commands = append(commands, "")
+	}
+	// The size buckets of the items are based on the chunk sizes of the slabs.
+	if cfg := e.slabsConfig(server); set[CollectorSlabs] || set[CollectorCgroup] || (set[CollectorItems] && cfg != nil && len(cfg.SizeBuckets) > 0) {
+		commands = append(commands, "slabs")
+	}
+	if set[CollectorItems] {
+		commands = append(commands, "items")
+	}
+	return commands
+}
+
+func newStats() memcache.Stats {
+	return memcache.Stats{
+		Stats: map[string]string{},
+		Slabs: map[int]map[string]string{},
+		Items: map[int]map[string]string{},
+	}
+}
+
+// groupStats adds the output s of a stats command to stats, grouping the stats
+// of slabs and items by slab class.
+func groupStats(stats memcache.Stats, s map[string]string) error {
+	for key, value := range s {
+		m, field, err := statsMap(stats, key, true)
+		if err != nil {
+			return err
+		}
+		if m != nil {
+			m[field] = value
+		}
+	}
+	return nil
+}
+
+// statsMap returns the map of stats holding key of a stats command output, and
+// the name of the key in it. If create is set, missing maps of slab classes
+// are created.
+func statsMap(stats memcache.Stats, key string, create bool) (map[string]string, string, error) {
+	f := strings.Split(key, ":")
+	var (
+		slabs map[int]map[string]string
+		slab  string
+	)
+	switch len(f) {
+	case 1:
+		// Global stats
+		return stats.Stats, key, nil
+	case 2:
+		// Slab stats
+		slabs, slab, key = stats.Slabs, f[0], f[1]
+	case 3:
+		// Slab item stats
+		slabs, slab, key = stats.Items, f[1], f[2]
+	default:
+		return nil, "", nil
+	}
+	n, err := strconv.Atoi(slab)
 	if err != nil {
-		level.Error(e.logger).Log("msg", "Could not query stats settings", "err", err)
-		up = 0
+		return nil, "", err
 	}
+	if slabs[n] == nil && create {
+		slabs[n] = map[string]string{}
+	}
+	return slabs[n], key, nil
+}
 
-	if err := e.parseStats(ch, stats, server); err != nil {
-		up = 0
+// subtractOwnConnections removes the connections of the exporter's pool from
+// the connection stats of server.
+func (e *Exporter) subtractOwnConnections(server string, stats map[string]string) {
+	if current, err := strconv.ParseFloat(stats["curr_connections"], 64); err == nil {
+		current = math.Max(current-float64(e.connPool.Open(server)), 0)
+		stats["curr_connections"] = strconv.FormatFloat(current, 'f', -1, 64)
 	}
-	if err := e.parseStatsSettings(ch, statsSettings, server); err != nil {
-		up = 0
+
+	uptime, err := strconv.ParseFloat(stats["uptime"], 64)
+	if err != nil {
+		return
+	}
+	// The uptime is reported in whole seconds, allow for one second of
+	// rounding so that connections dialed right after the start count.
+	since := time.Now().Add(-time.Duration(uptime+1) * time.Second)
+	if total, err := strconv.ParseFloat(stats["total_connections"], 64); err == nil {
+		total = math.Max(total-float64(e.connPool.Dials(server, since)), 0)
+		stats["total_connections"] = strconv.FormatFloat(total, 'f', -1, 64)
 	}
+}
 
-	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, up, server)
+// label applies the alias and additional labels of server to m.
+func (e *Exporter) label(server string, m prometheus.Metric) prometheus.Metric {
+	if label := e.labeler(server); label != nil {
+		return label(m)
+	}
+	return m
+}
+
+// Addresses returns the addresses of the servers of the exporter. The slice
+// must not be modified.
+func (e *Exporter) Addresses() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.addresses
+}
+
+// SetAddresses replaces the addresses of the servers of the exporter, e.g.
+// when they are discovered. pids maps addresses to the pids of the processes
+// of the servers, for the process collector; it is nil if they are unknown.
+// Servers that are removed are no longer polled, and what is known about them
+// is forgotten. The pools of the exporter are not affected.
+func (e *Exporter) SetAddresses(addresses []string, pids map[string]int) {
+	e.mutex.Lock()
+	current := map[string]bool{}
+	for _, address := range addresses {
+		current[address] = true
+	}
+	for _, address := range e.addresses {
+		if !current[address] {
+			delete(e.snapshots, address)
+			delete(e.settings, address)
+			delete(e.answered, address)
+			delete(e.lastCommands, address)
+		}
+	}
+	e.addresses = addresses
+	e.pids = pids
+	e.mutex.Unlock()
+
+	select {
+	case e.addressesChanged <- struct{}{}:
+	default:
+	}
+}
+
+// hasAddresses reports whether all servers are among the exporter's addresses.
+func (e *Exporter) hasAddresses(servers []config.PoolServerConfig) bool {
+	for _, s := range servers {
+		found := false
+		for _, address := range e.addresses {
+			if s.Address == address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
-func (e *Exporter) parseStats(ch chan<- prometheus.Metric, stats map[net.Addr]memcache.Stats, server string) error {
+func (e *Exporter) parseStats(ch chan<- prometheus.Metric, stats map[net.Addr]memcache.Stats, server string, set collectorSet, results *keyResults) error {
+	p := statsParser{Exporter: e, results: results}
 	// TODO(ts): Clean up and consolidate metric mappings.
 	itemsCounterMetrics := map[string]*prometheus.Desc{
 		"crawler_reclaimed": e.itemsCrawlerReclaimed,
@@ -843,167 +1478,192 @@ func (e *Exporter) parseStats(ch chan<- prometheus.Metric, stats map[net.Addr]me
 	var parseError error
 	for _, t := range stats {
 		s := t.Stats
-		ch <- prometheus.MustNewConstMetric(e.version, prometheus.GaugeValue, 1, s["version"], server)
+		if set[CollectorGeneral] {
+			version := prometheus.MustNewConstMetric(e.version, prometheus.GaugeValue, 1, s["version"], server)
+			ch <- version
+			p.record(s, nil, version, "version")
 
-		for _, op := range []string{"get", "delete", "incr", "decr", "cas", "touch"} {
+			for _, op := range []string{"get", "delete", "incr", "decr", "cas", "touch"} {
+				err := firstError(
+					p.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, op+"_hits", op, "hit", server),
+					p.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, op+"_misses", op, "miss", server),
+				)
+				if err != nil {
+					parseError = err
+				}
+			}
 			err := firstError(
-				e.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, op+"_hits", op, "hit", server),
-				e.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, op+"_misses", op, "miss", server),
+				p.parseAndNewMetric(ch, e.uptime, prometheus.CounterValue, s, "uptime", server),
+				p.parseAndNewMetric(ch, e.time, prometheus.GaugeValue, s, "time", server),
+				p.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, "cas_badval", "cas", "badval", server),
+				p.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, "cmd_flush", "flush", "hit", server),
 			)
 			if err != nil {
 				parseError = err
 			}
-		}
-		err := firstError(
-			e.parseAndNewMetric(ch, e.uptime, prometheus.CounterValue, s, "uptime", server),
-			e.parseAndNewMetric(ch, e.time, prometheus.GaugeValue, s, "time", server),
-			e.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, "cas_badval", "cas", "badval", server),
-			e.parseAndNewMetric(ch, e.commands, prometheus.CounterValue, s, "cmd_flush", "flush", "hit", server),
-		)
-		if err != nil {
-			parseError = err
-		}
 
-		// memcached includes cas operations again in cmd_set.
-		setCmd, err := parse(s, "cmd_set", e.logger)
-		if err == nil {
-			if cas, casErr := sum(s, "cas_misses", "cas_hits", "cas_badval"); casErr == nil {
-				ch <- prometheus.MustNewConstMetric(e.commands, prometheus.CounterValue, setCmd-cas, "set", "hit", server)
+			// memcached includes cas operations again in cmd_set.
+			setCmd, err := parse(s, "cmd_set", e.logger)
+			if err == nil {
+				cas, casErr := sum(s, "cas_misses", "cas_hits", "cas_badval")
+				if casErr == nil {
+					setMetric := prometheus.MustNewConstMetric(e.commands, prometheus.CounterValue, setCmd-cas, "set", "hit", server)
+					ch <- setMetric
+					p.record(s, nil, setMetric, "cmd_set", "cas_misses", "cas_hits", "cas_badval")
+				} else {
+					p.record(s, casErr, nil, "cas_misses", "cas_hits", "cas_badval")
+					level.Error(e.logger).Log("msg", "Failed to parse cas", "err", casErr)
+					parseError = casErr
+				}
 			} else {
-				level.Error(e.logger).Log("msg", "Failed to parse cas", "err", casErr)
-				parseError = casErr
+				p.record(s, err, nil, "cmd_set")
+				level.Error(e.logger).Log("msg", "Failed to parse set", "err", err)
+				parseError = err
 			}
-		} else {
-			level.Error(e.logger).Log("msg", "Failed to parse set", "err", err)
-			parseError = err
 		}
 
 		// extstore stats are only included if extstore is actually active. Take the presence of the
 		// maxbytes key as a signal that they all should be there and do the parsing
-		if _, ok := s["extstore_limit_maxbytes"]; ok {
-			err = firstError(
-				e.parseAndNewMetric(ch, e.extstoreCompactLost, prometheus.CounterValue, s, "extstore_compact_lost", server),
-				e.parseAndNewMetric(ch, e.extstoreCompactRescues, prometheus.CounterValue, s, "extstore_compact_rescues", server),
-				e.parseAndNewMetric(ch, e.extstoreCompactSkipped, prometheus.CounterValue, s, "extstore_compact_skipped", server),
-				e.parseAndNewMetric(ch, e.extstorePageAllocs, prometheus.CounterValue, s, "extstore_page_allocs", server),
-				e.parseAndNewMetric(ch, e.extstorePageEvictions, prometheus.CounterValue, s, "extstore_page_evictions", server),
-				e.parseAndNewMetric(ch, e.extstorePageReclaims, prometheus.CounterValue, s, "extstore_page_reclaims", server),
-				e.parseAndNewMetric(ch, e.extstorePagesFree, prometheus.GaugeValue, s, "extstore_pages_free", server),
-				e.parseAndNewMetric(ch, e.extstorePagesUsed, prometheus.GaugeValue, s, "extstore_pages_used", server),
-				e.parseAndNewMetric(ch, e.extstoreObjectsEvicted, prometheus.CounterValue, s, "extstore_objects_evicted", server),
-				e.parseAndNewMetric(ch, e.extstoreObjectsRead, prometheus.CounterValue, s, "extstore_objects_read", server),
-				e.parseAndNewMetric(ch, e.extstoreObjectsWritten, prometheus.CounterValue, s, "extstore_objects_written", server),
-				e.parseAndNewMetric(ch, e.extstoreObjectsUsed, prometheus.GaugeValue, s, "extstore_objects_used", server),
-				e.parseAndNewMetric(ch, e.extstoreBytesEvicted, prometheus.CounterValue, s, "extstore_bytes_evicted", server),
-				e.parseAndNewMetric(ch, e.extstoreBytesWritten, prometheus.CounterValue, s, "extstore_bytes_written", server),
-				e.parseAndNewMetric(ch, e.extstoreBytesRead, prometheus.CounterValue, s, "extstore_bytes_read", server),
-				e.parseAndNewMetric(ch, e.extstoreBytesUsed, prometheus.CounterValue, s, "extstore_bytes_used", server),
-				e.parseAndNewMetric(ch, e.extstoreBytesFragmented, prometheus.GaugeValue, s, "extstore_bytes_fragmented", server),
-				e.parseAndNewMetric(ch, e.extstoreBytesLimit, prometheus.GaugeValue, s, "extstore_limit_maxbytes", server),
-				e.parseAndNewMetric(ch, e.extstoreIOQueueDepth, prometheus.GaugeValue, s, "extstore_io_queue", server),
+		if _, ok := s["extstore_limit_maxbytes"]; ok && set[CollectorExtstore] {
+			err := firstError(
+				p.parseAndNewMetric(ch, e.extstoreCompactLost, prometheus.CounterValue, s, "extstore_compact_lost", server),
+				p.parseAndNewMetric(ch, e.extstoreCompactRescues, prometheus.CounterValue, s, "extstore_compact_rescues", server),
+				p.parseAndNewMetric(ch, e.extstoreCompactSkipped, prometheus.CounterValue, s, "extstore_compact_skipped", server),
+				p.parseAndNewMetric(ch, e.extstorePageAllocs, prometheus.CounterValue, s, "extstore_page_allocs", server),
+				p.parseAndNewMetric(ch, e.extstorePageEvictions, prometheus.CounterValue, s, "extstore_page_evictions", server),
+				p.parseAndNewMetric(ch, e.extstorePageReclaims, prometheus.CounterValue, s, "extstore_page_reclaims", server),
+				p.parseAndNewMetric(ch, e.extstorePagesFree, prometheus.GaugeValue, s, "extstore_pages_free", server),
+				p.parseAndNewMetric(ch, e.extstorePagesUsed, prometheus.GaugeValue, s, "extstore_pages_used", server),
+				p.parseAndNewMetric(ch, e.extstoreObjectsEvicted, prometheus.CounterValue, s, "extstore_objects_evicted", server),
+				p.parseAndNewMetric(ch, e.extstoreObjectsRead, prometheus.CounterValue, s, "extstore_objects_read", server),
+				p.parseAndNewMetric(ch, e.extstoreObjectsWritten, prometheus.CounterValue, s, "extstore_objects_written", server),
+				p.parseAndNewMetric(ch, e.extstoreObjectsUsed, prometheus.GaugeValue, s, "extstore_objects_used", server),
+				p.parseAndNewMetric(ch, e.extstoreBytesEvicted, prometheus.CounterValue, s, "extstore_bytes_evicted", server),
+				p.parseAndNewMetric(ch, e.extstoreBytesWritten, prometheus.CounterValue, s, "extstore_bytes_written", server),
+				p.parseAndNewMetric(ch, e.extstoreBytesRead, prometheus.CounterValue, s, "extstore_bytes_read", server),
+				p.parseAndNewMetric(ch, e.extstoreBytesUsed, prometheus.CounterValue, s, "extstore_bytes_used", server),
+				p.parseAndNewMetric(ch, e.extstoreBytesFragmented, prometheus.GaugeValue, s, "extstore_bytes_fragmented", server),
+				p.parseAndNewMetric(ch, e.extstoreBytesLimit, prometheus.GaugeValue, s, "extstore_limit_maxbytes", server),
+				p.parseAndNewMetric(ch, e.extstoreIOQueueDepth, prometheus.GaugeValue, s, "extstore_io_queue", server),
 			)
 			if err != nil {
 				parseError = err
 			}
 		}
 
-		err = firstError(
-			e.parseTimevalAndNewMetric(ch, e.rusageUser, prometheus.CounterValue, s, "rusage_user", server),
-			e.parseTimevalAndNewMetric(ch, e.rusageSystem, prometheus.CounterValue, s, "rusage_system", server),
-			e.parseAndNewMetric(ch, e.currentBytes, prometheus.GaugeValue, s, "bytes", server),
-			e.parseAndNewMetric(ch, e.limitBytes, prometheus.GaugeValue, s, "limit_maxbytes", server),
-			e.parseAndNewMetric(ch, e.items, prometheus.GaugeValue, s, "curr_items", server),
-			e.parseAndNewMetric(ch, e.itemsTotal, prometheus.CounterValue, s, "total_items", server),
-			e.parseAndNewMetric(ch, e.bytesRead, prometheus.CounterValue, s, "bytes_read", server),
-			e.parseAndNewMetric(ch, e.bytesWritten, prometheus.CounterValue, s, "bytes_written", server),
-			e.parseAndNewMetric(ch, e.currentConnections, prometheus.GaugeValue, s, "curr_connections", server),
-			e.parseAndNewMetric(ch, e.connectionsTotal, prometheus.CounterValue, s, "total_connections", server),
-			e.parseAndNewMetric(ch, e.rejectedConnections, prometheus.CounterValue, s, "rejected_connections", server),
-			e.parseAndNewMetric(ch, e.connsYieldedTotal, prometheus.CounterValue, s, "conn_yields", server),
-			e.parseAndNewMetric(ch, e.listenerDisabledTotal, prometheus.CounterValue, s, "listen_disabled_num", server),
-			e.parseAndNewMetric(ch, e.evictions, prometheus.CounterValue, s, "evictions", server),
-			e.parseAndNewMetric(ch, e.reclaimed, prometheus.CounterValue, s, "reclaimed", server),
-			e.parseAndNewMetric(ch, e.lruCrawlerStarts, prometheus.CounterValue, s, "lru_crawler_starts", server),
-			e.parseAndNewMetric(ch, e.lruCrawlerItemsChecked, prometheus.CounterValue, s, "crawler_items_checked", server),
-			e.parseAndNewMetric(ch, e.lruCrawlerReclaimed, prometheus.CounterValue, s, "crawler_reclaimed", server),
-			e.parseAndNewMetric(ch, e.lruCrawlerMovesToCold, prometheus.CounterValue, s, "moves_to_cold", server),
-			e.parseAndNewMetric(ch, e.lruCrawlerMovesToWarm, prometheus.CounterValue, s, "moves_to_warm", server),
-			e.parseAndNewMetric(ch, e.lruCrawlerMovesWithinLru, prometheus.CounterValue, s, "moves_within_lru", server),
-			e.parseAndNewMetric(ch, e.malloced, prometheus.GaugeValue, s, "total_malloced", server),
-			e.parseAndNewMetric(ch, e.acceptingConnections, prometheus.GaugeValue, s, "accepting_conns", server),
-		)
-		if err != nil {
-			parseError = err
-		}
-
-		for slab, u := range t.Items {
-			slab := strconv.Itoa(slab)
+		if set[CollectorGeneral] {
 			err := firstError(
-				e.parseAndNewMetric(ch, e.itemsNumber, prometheus.GaugeValue, u, "number", slab, server),
-				e.parseAndNewMetric(ch, e.itemsAge, prometheus.GaugeValue, u, "age", slab, server),
-				e.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_hot", slab, "hot", server),
-				e.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_warm", slab, "warm", server),
-				e.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_cold", slab, "cold", server),
-				e.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_temp", slab, "temporary", server),
+				p.parseTimevalAndNewMetric(ch, e.rusageUser, prometheus.CounterValue, s, "rusage_user", server),
+				p.parseTimevalAndNewMetric(ch, e.rusageSystem, prometheus.CounterValue, s, "rusage_system", server),
+				p.parseAndNewMetric(ch, e.currentBytes, prometheus.GaugeValue, s, "bytes", server),
+				p.parseAndNewMetric(ch, e.limitBytes, prometheus.GaugeValue, s, "limit_maxbytes", server),
+				p.parseAndNewMetric(ch, e.items, prometheus.GaugeValue, s, "curr_items", server),
+				p.parseAndNewMetric(ch, e.itemsTotal, prometheus.CounterValue, s, "total_items", server),
+				p.parseAndNewMetric(ch, e.bytesRead, prometheus.CounterValue, s, "bytes_read", server),
+				p.parseAndNewMetric(ch, e.bytesWritten, prometheus.CounterValue, s, "bytes_written", server),
+				p.parseAndNewMetric(ch, e.currentConnections, prometheus.GaugeValue, s, "curr_connections", server),
+				p.parseAndNewMetric(ch, e.connectionsTotal, prometheus.CounterValue, s, "total_connections", server),
+				p.parseAndNewMetric(ch, e.rejectedConnections, prometheus.CounterValue, s, "rejected_connections", server),
+				p.parseAndNewMetric(ch, e.connsYieldedTotal, prometheus.CounterValue, s, "conn_yields", server),
+				p.parseAndNewMetric(ch, e.listenerDisabledTotal, prometheus.CounterValue, s, "listen_disabled_num", server),
+				p.parseAndNewMetric(ch, e.evictions, prometheus.CounterValue, s, "evictions", server),
+				p.parseAndNewMetric(ch, e.reclaimed, prometheus.CounterValue, s, "reclaimed", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerStarts, prometheus.CounterValue, s, "lru_crawler_starts", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerItemsChecked, prometheus.CounterValue, s, "crawler_items_checked", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerReclaimed, prometheus.CounterValue, s, "crawler_reclaimed", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerMovesToCold, prometheus.CounterValue, s, "moves_to_cold", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerMovesToWarm, prometheus.CounterValue, s, "moves_to_warm", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerMovesWithinLru, prometheus.CounterValue, s, "moves_within_lru", server),
+				p.parseAndNewMetric(ch, e.acceptingConnections, prometheus.GaugeValue, s, "accepting_conns", server),
 			)
 			if err != nil {
 				parseError = err
 			}
-			for m, d := range itemsCounterMetrics {
-				if _, ok := u[m]; !ok {
-					continue
-				}
-				if err := e.parseAndNewMetric(ch, d, prometheus.CounterValue, u, m, slab, server); err != nil {
+		}
+
+		if set[CollectorItems] {
+			for slab, u := range t.Items {
+				slab := strconv.Itoa(slab)
+				ip := p.in("items:" + slab + ":")
+				err := firstError(
+					ip.parseAndNewMetric(ch, e.itemsNumber, prometheus.GaugeValue, u, "number", slab, server),
+					ip.parseAndNewMetric(ch, e.itemsAge, prometheus.GaugeValue, u, "age", slab, server),
+					ip.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_hot", slab, "hot", server),
+					ip.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_warm", slab, "warm", server),
+					ip.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_cold", slab, "cold", server),
+					ip.parseAndNewMetric(ch, e.itemsLruHits, prometheus.CounterValue, u, "hits_to_temp", slab, "temporary", server),
+				)
+				if err != nil {
 					parseError = err
 				}
-			}
-			for m, d := range itemsGaugeMetrics {
-				if _, ok := u[m]; !ok {
-					continue
+				for m, d := range itemsCounterMetrics {
+					if _, ok := u[m]; !ok {
+						continue
+					}
+					if err := ip.parseAndNewMetric(ch, d, prometheus.CounterValue, u, m, slab, server); err != nil {
+						parseError = err
+					}
 				}
-				if err := e.parseAndNewMetric(ch, d, prometheus.GaugeValue, u, m, slab, server); err != nil {
-					parseError = err
+				for m, d := range itemsGaugeMetrics {
+					if _, ok := u[m]; !ok {
+						continue
+					}
+					if err := ip.parseAndNewMetric(ch, d, prometheus.GaugeValue, u, m, slab, server); err != nil {
+						parseError = err
+					}
 				}
 			}
+
 		}
 
-		for slab, v := range t.Slabs {
-			slab := strconv.Itoa(slab)
+		if set[CollectorSlabs] {
+			if err := p.parseAndNewMetric(ch, e.malloced, prometheus.GaugeValue, s, "total_malloced", server); err != nil {
+				parseError = err
+			}
+			for slab, v := range t.Slabs {
+				slab := strconv.Itoa(slab)
+				sp := p.in(slab + ":")
 
-			for _, op := range []string{"get", "delete", "incr", "decr", "cas", "touch"} {
-				if err := e.parseAndNewMetric(ch, e.slabsCommands, prometheus.CounterValue, v, op+"_hits", slab, op, "hit", server); err != nil {
+				for _, op := range []string{"get", "delete", "incr", "decr", "cas", "touch"} {
+					if err := sp.parseAndNewMetric(ch, e.slabsCommands, prometheus.CounterValue, v, op+"_hits", slab, op, "hit", server); err != nil {
+						parseError = err
+					}
+				}
+				if err := sp.parseAndNewMetric(ch, e.slabsCommands, prometheus.CounterValue, v, "cas_badval", slab, "cas", "badval", server); err != nil {
 					parseError = err
 				}
-			}
-			if err := e.parseAndNewMetric(ch, e.slabsCommands, prometheus.CounterValue, v, "cas_badval", slab, "cas", "badval", server); err != nil {
-				parseError = err
-			}
 
-			slabSetCmd, err := parse(v, "cmd_set", e.logger)
-			if err == nil {
-				if slabCas, slabCasErr := sum(v, "cas_hits", "cas_badval"); slabCasErr == nil {
-					ch <- prometheus.MustNewConstMetric(e.slabsCommands, prometheus.CounterValue, slabSetCmd-slabCas, slab, "set", "hit", server)
+				slabSetCmd, err := parse(v, "cmd_set", e.logger)
+				if err == nil {
+					slabCas, slabCasErr := sum(v, "cas_hits", "cas_badval")
+					if slabCasErr == nil {
+						setMetric := prometheus.MustNewConstMetric(e.slabsCommands, prometheus.CounterValue, slabSetCmd-slabCas, slab, "set", "hit", server)
+						ch <- setMetric
+						sp.record(v, nil, setMetric, "cmd_set", "cas_hits", "cas_badval")
+					} else {
+						sp.record(v, slabCasErr, nil, "cas_hits", "cas_badval")
+						level.Error(e.logger).Log("msg", "Failed to parse cas", "err", slabCasErr)
+						parseError = slabCasErr
+					}
 				} else {
-					level.Error(e.logger).Log("msg", "Failed to parse cas", "err", slabCasErr)
-					parseError = slabCasErr
+					sp.record(v, err, nil, "cmd_set")
+					level.Error(e.logger).Log("msg", "Failed to parse set", "err", err)
+					parseError = err
 				}
-			} else {
-				level.Error(e.logger).Log("msg", "Failed to parse set", "err", err)
-				parseError = err
-			}
 
-			err = firstError(
-				e.parseAndNewMetric(ch, e.slabsChunkSize, prometheus.GaugeValue, v, "chunk_size", slab, server),
-				e.parseAndNewMetric(ch, e.slabsChunksPerPage, prometheus.GaugeValue, v, "chunks_per_page", slab, server),
-				e.parseAndNewMetric(ch, e.slabsCurrentPages, prometheus.GaugeValue, v, "total_pages", slab, server),
-				e.parseAndNewMetric(ch, e.slabsCurrentChunks, prometheus.GaugeValue, v, "total_chunks", slab, server),
-				e.parseAndNewMetric(ch, e.slabsChunksUsed, prometheus.GaugeValue, v, "used_chunks", slab, server),
-				e.parseAndNewMetric(ch, e.slabsChunksFree, prometheus.GaugeValue, v, "free_chunks", slab, server),
-				e.parseAndNewMetric(ch, e.slabsChunksFreeEnd, prometheus.GaugeValue, v, "free_chunks_end", slab, server),
-				e.parseAndNewMetric(ch, e.slabsMemRequested, prometheus.GaugeValue, v, "mem_requested", slab, server),
-			)
-			if err != nil {
-				parseError = err
+				err = firstError(
+					sp.parseAndNewMetric(ch, e.slabsChunkSize, prometheus.GaugeValue, v, "chunk_size", slab, server),
+					sp.parseAndNewMetric(ch, e.slabsChunksPerPage, prometheus.GaugeValue, v, "chunks_per_page", slab, server),
+					sp.parseAndNewMetric(ch, e.slabsCurrentPages, prometheus.GaugeValue, v, "total_pages", slab, server),
+					sp.parseAndNewMetric(ch, e.slabsCurrentChunks, prometheus.GaugeValue, v, "total_chunks", slab, server),
+					sp.parseAndNewMetric(ch, e.slabsChunksUsed, prometheus.GaugeValue, v, "used_chunks", slab, server),
+					sp.parseAndNewMetric(ch, e.slabsChunksFree, prometheus.GaugeValue, v, "free_chunks", slab, server),
+					sp.parseAndNewMetric(ch, e.slabsChunksFreeEnd, prometheus.GaugeValue, v, "free_chunks_end", slab, server),
+					sp.parseAndNewMetric(ch, e.slabsMemRequested, prometheus.GaugeValue, v, "mem_requested", slab, server),
+				)
+				if err != nil {
+					parseError = err
+				}
 			}
 		}
 	}
@@ -1011,23 +1671,24 @@ func (e *Exporter) parseStats(ch chan<- prometheus.Metric, stats map[net.Addr]me
 	return parseError
 }
 
-func (e *Exporter) parseStatsSettings(ch chan<- prometheus.Metric, statsSettings map[net.Addr]map[string]string, server string) error {
+func (e *Exporter) parseStatsSettings(ch chan<- prometheus.Metric, statsSettings map[net.Addr]map[string]string, server string, results *keyResults) error {
+	p := statsParser{Exporter: e, results: results}
 	var parseError error
 	for _, settings := range statsSettings {
-		if err := e.parseAndNewMetric(ch, e.maxConnections, prometheus.GaugeValue, settings, "maxconns", server); err != nil {
+		if err := p.parseAndNewMetric(ch, e.maxConnections, prometheus.GaugeValue, settings, "maxconns", server); err != nil {
 			parseError = err
 		}
 
 		if v, ok := settings["lru_crawler"]; ok && v == "yes" {
 			err := firstError(
-				e.parseBoolAndNewMetric(ch, e.lruCrawlerEnabled, prometheus.GaugeValue, settings, "lru_crawler", server),
-				e.parseAndNewMetric(ch, e.lruCrawlerSleep, prometheus.GaugeValue, settings, "lru_crawler_sleep", server),
-				e.parseAndNewMetric(ch, e.lruCrawlerMaxItems, prometheus.GaugeValue, settings, "lru_crawler_tocrawl", server),
-				e.parseBoolAndNewMetric(ch, e.lruMaintainerThread, prometheus.GaugeValue, settings, "lru_maintainer_thread", server),
-				e.parseAndNewMetric(ch, e.lruHotPercent, prometheus.GaugeValue, settings, "hot_lru_pct", server),
-				e.parseAndNewMetric(ch, e.lruWarmPercent, prometheus.GaugeValue, settings, "warm_lru_pct", server),
-				e.parseAndNewMetric(ch, e.lruHotMaxAgeFactor, prometheus.GaugeValue, settings, "hot_max_factor", server),
-				e.parseAndNewMetric(ch, e.lruWarmMaxAgeFactor, prometheus.GaugeValue, settings, "warm_max_factor", server),
+				p.parseBoolAndNewMetric(ch, e.lruCrawlerEnabled, prometheus.GaugeValue, settings, "lru_crawler", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerSleep, prometheus.GaugeValue, settings, "lru_crawler_sleep", server),
+				p.parseAndNewMetric(ch, e.lruCrawlerMaxItems, prometheus.GaugeValue, settings, "lru_crawler_tocrawl", server),
+				p.parseBoolAndNewMetric(ch, e.lruMaintainerThread, prometheus.GaugeValue, settings, "lru_maintainer_thread", server),
+				p.parseAndNewMetric(ch, e.lruHotPercent, prometheus.GaugeValue, settings, "hot_lru_pct", server),
+				p.parseAndNewMetric(ch, e.lruWarmPercent, prometheus.GaugeValue, settings, "warm_lru_pct", server),
+				p.parseAndNewMetric(ch, e.lruHotMaxAgeFactor, prometheus.GaugeValue, settings, "hot_max_factor", server),
+				p.parseAndNewMetric(ch, e.lruWarmMaxAgeFactor, prometheus.GaugeValue, settings, "warm_max_factor", server),
 			)
 			if err != nil {
 				parseError = err
@@ -1037,28 +1698,60 @@ func (e *Exporter) parseStatsSettings(ch chan<- prometheus.Metric, statsSettings
 	return parseError
 }
 
-func (e *Exporter) parseAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, stats map[string]string, key string, labelValues ...string) error {
-	return e.extractValueAndNewMetric(ch, desc, valueType, parse, stats, key, labelValues...)
+// statsParser parses stats into metrics. If results is not nil, the result of
+// parsing each key is recorded in it, prefixed with prefix like in the output of
+// the stats commands.
+type statsParser struct {
+	*Exporter
+	results *keyResults
+	prefix  string
+}
+
+// in returns a parser of the stats of the keys with prefix, e.g. those of a
+// slab class.
+func (p statsParser) in(prefix string) statsParser {
+	p.prefix = prefix
+	return p
+}
+
+// record records the result of parsing keys of stats into m, if the results
+// are collected.
+func (p statsParser) record(stats map[string]string, err error, m prometheus.Metric, keys ...string) {
+	if p.results == nil {
+		return
+	}
+	for _, key := range keys {
+		if _, ok := stats[key]; ok {
+			p.results.add(p.prefix+key, err, m)
+		}
+	}
+}
+
+func (p statsParser) parseAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, stats map[string]string, key string, labelValues ...string) error {
+	return p.extractValueAndNewMetric(ch, desc, valueType, parse, stats, key, labelValues...)
 }
 
-func (e *Exporter) parseBoolAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, stats map[string]string, key string, labelValues ...string) error {
-	return e.extractValueAndNewMetric(ch, desc, valueType, parseBool, stats, key, labelValues...)
+func (p statsParser) parseBoolAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, stats map[string]string, key string, labelValues ...string) error {
+	return p.extractValueAndNewMetric(ch, desc, valueType, parseBool, stats, key, labelValues...)
 }
 
-func (e *Exporter) parseTimevalAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, stats map[string]string, key string, labelValues ...string) error {
-	return e.extractValueAndNewMetric(ch, desc, valueType, parseTimeval, stats, key, labelValues...)
+func (p statsParser) parseTimevalAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, stats map[string]string, key string, labelValues ...string) error {
+	return p.extractValueAndNewMetric(ch, desc, valueType, parseTimeval, stats, key, labelValues...)
 }
 
-func (e *Exporter) extractValueAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, f func(map[string]string, string, log.Logger) (float64, error), stats map[string]string, key string, labelValues ...string) error {
-	v, err := f(stats, key, e.logger)
+func (p statsParser) extractValueAndNewMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, f func(map[string]string, string, log.Logger) (float64, error), stats map[string]string, key string, labelValues ...string) error {
+	v, err := f(stats, key, p.logger)
 	if err == errKeyNotFound {
 		return nil
 	}
 	if err != nil {
+		p.record(stats, err, nil, key)
 		return err
 	}
 
-	ch <- prometheus.MustNewConstMetric(desc, valueType, v, labelValues...)
+	m := prometheus.MustNewConstMetric(desc, valueType, v, labelValues...)
+	ch <- m
+	p.record(stats, nil, m, key)
 	return nil
 }
 
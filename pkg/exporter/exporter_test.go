@@ -14,14 +14,79 @@
 package exporter
 
 import (
+	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tdewolff/memcached_exporter/connpool"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
 )
 
+func TestCollectServer(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	pool := connpool.New(time.Second, nil, time.Minute)
+	defer pool.Close()
+
+	e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithConnPool(pool), WithExcludeOwnConnections(true))
+	names := []string{
+		"memcached_up",
+		"memcached_current_connections",
+		"memcached_connections_total",
+		"memcached_malloced_bytes",
+		"memcached_slab_chunk_size_bytes",
+		"memcached_slab_current_items",
+		"memcached_max_connections",
+	}
+	for i := 0; i < 2; i++ {
+		expected := `
+# HELP memcached_connections_total Total number of connections opened since the server started running.
+# TYPE memcached_connections_total counter
+memcached_connections_total{server="SERVER"} 99
+# HELP memcached_current_connections Current number of open connections.
+# TYPE memcached_current_connections gauge
+memcached_current_connections{server="SERVER"} 9
+# HELP memcached_malloced_bytes Number of bytes of memory allocated to slab pages.
+# TYPE memcached_malloced_bytes gauge
+memcached_malloced_bytes{server="SERVER"} 1.048576e+06
+# HELP memcached_max_connections Maximum number of clients allowed.
+# TYPE memcached_max_connections gauge
+memcached_max_connections{server="SERVER"} 1024
+# HELP memcached_slab_chunk_size_bytes Number of bytes allocated to each chunk within this slab class.
+# TYPE memcached_slab_chunk_size_bytes gauge
+memcached_slab_chunk_size_bytes{server="SERVER",slab="1"} 96
+# HELP memcached_slab_current_items Number of items currently stored in this slab class.
+# TYPE memcached_slab_current_items gauge
+memcached_slab_current_items{server="SERVER",slab="1"} 3
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{server="SERVER"} 1
+`
+		expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+			t.Error(err)
+		}
+	}
+	if n := server.Connections(); n != 1 {
+		t.Errorf("expected pooled connection to be reused, got %d connections", n)
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	addresses, err := ParseAddresses("localhost:11211,/run/memcached/[*.sock,10.0.0.1:11211")
+	if err == nil {
+		t.Error("want error for malformed pattern")
+	}
+	if got := strings.Join(addresses, ","); got != "localhost:11211,10.0.0.1:11211" {
+		t.Errorf("got addresses %q, want the well-formed ones", got)
+	}
+}
+
 func TestParseStatsSettings(t *testing.T) {
 	addr, err := net.ResolveIPAddr("ip4", "127.0.0.1")
 	if err != nil {
@@ -47,7 +112,7 @@ func TestParseStatsSettings(t *testing.T) {
 		}
 		ch := make(chan prometheus.Metric, 100)
 		e := New("", 100*time.Millisecond, log.NewNopLogger(), nil)
-		if err := e.parseStatsSettings(ch, statsSettings, "server"); err != nil {
+		if err := e.parseStatsSettings(ch, statsSettings, "server", nil); err != nil {
 			t.Errorf("expect return error, error: %v", err)
 		}
 	})
@@ -70,7 +135,7 @@ func TestParseStatsSettings(t *testing.T) {
 		}
 		ch := make(chan prometheus.Metric, 100)
 		e := New("", 100*time.Millisecond, log.NewNopLogger(), nil)
-		if err := e.parseStatsSettings(ch, statsSettings, "server"); err == nil {
+		if err := e.parseStatsSettings(ch, statsSettings, "server", nil); err == nil {
 			t.Error("expect return error but not")
 		}
 	})
@@ -93,3 +158,25 @@ func TestParseTimeval(t *testing.T) {
 		}
 	})
 }
+
+func TestMaxConcurrency(t *testing.T) {
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		addresses = append(addresses, memcachedtest.NewServer(t, memcachedtest.Stats()).Addr)
+	}
+	e := New(strings.Join(addresses, ","), time.Second, log.NewNopLogger(), nil, WithMaxConcurrency(1))
+
+	expected := `
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{server="SERVER0"} 1
+memcached_up{server="SERVER1"} 1
+memcached_up{server="SERVER2"} 1
+`
+	for i, address := range addresses {
+		expected = strings.ReplaceAll(expected, fmt.Sprintf("SERVER%d", i), address)
+	}
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "memcached_up"); err != nil {
+		t.Error(err)
+	}
+}
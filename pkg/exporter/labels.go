@@ -0,0 +1,126 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+// descSpec holds the arguments a Desc was created with, so that it can be
+// recreated with additional labels.
+type descSpec struct {
+	fqName         string
+	help           string
+	variableLabels []string
+	constLabels    prometheus.Labels
+}
+
+// target is a target configuration along with the descriptors of the
+// exporter extended by its additional labels.
+type target struct {
+	config.TargetConfig
+	names []string
+	descs map[*prometheus.Desc]*prometheus.Desc
+}
+
+func newTarget(cfg config.TargetConfig, specs map[*prometheus.Desc]descSpec) target {
+	t := target{
+		TargetConfig: cfg,
+		names:        cfg.LabelNames(),
+		descs:        make(map[*prometheus.Desc]*prometheus.Desc, len(specs)),
+	}
+	for desc, spec := range specs {
+		labels := append(append([]string{}, spec.variableLabels...), t.names...)
+		t.descs[desc] = prometheus.NewDesc(spec.fqName, spec.help, labels, spec.constLabels)
+	}
+	return t
+}
+
+// labels returns the alias and the additional label pairs of server, which
+// must match the target.
+func (t *target) labels(server string, match []int) (string, []*dto.LabelPair) {
+	alias := server
+	if t.Alias != "" {
+		alias = string(t.Match.ExpandString(nil, t.Alias, server, match))
+	}
+
+	pairs := make([]*dto.LabelPair, 0, len(t.names))
+	for _, name := range t.names {
+		name := name
+		var value string
+		if tmpl, ok := t.Labels[name]; ok {
+			value = string(t.Match.ExpandString(nil, tmpl, server, match))
+		} else if i := t.Match.SubexpIndex(name); i >= 0 && match[2*i] >= 0 {
+			value = server[match[2*i]:match[2*i+1]]
+		}
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return alias, pairs
+}
+
+// labeler returns a function that applies the alias and additional labels of
+// the first target matching server to a metric, or nil if no target matches.
+func (e *Exporter) labeler(server string) func(prometheus.Metric) prometheus.Metric {
+	for i := range e.targets {
+		t := &e.targets[i]
+		match := t.Match.FindStringSubmatchIndex(server)
+		if match == nil {
+			continue
+		}
+		alias, pairs := t.labels(server, match)
+		return func(m prometheus.Metric) prometheus.Metric {
+			return &labeledMetric{
+				Metric: m,
+				desc:   t.descs[m.Desc()],
+				alias:  alias,
+				pairs:  pairs,
+			}
+		}
+	}
+	return nil
+}
+
+// labeledMetric replaces the server label of a metric by an alias and adds
+// additional label pairs.
+type labeledMetric struct {
+	prometheus.Metric
+	desc  *prometheus.Desc
+	alias string
+	pairs []*dto.LabelPair
+}
+
+func (m *labeledMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	for _, lp := range out.Label {
+		if lp.GetName() == "server" {
+			lp.Value = &m.alias
+		}
+	}
+	out.Label = append(out.Label, m.pairs...)
+	sort.Slice(out.Label, func(i, j int) bool {
+		return out.Label[i].GetName() < out.Label[j].GetName()
+	})
+	return nil
+}
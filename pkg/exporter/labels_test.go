@@ -0,0 +1,74 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+type collectorFunc struct {
+	describe func(chan<- *prometheus.Desc)
+	collect  func(chan<- prometheus.Metric)
+}
+
+func (c collectorFunc) Describe(ch chan<- *prometheus.Desc) { c.describe(ch) }
+func (c collectorFunc) Collect(ch chan<- prometheus.Metric) { c.collect(ch) }
+
+func TestLabeler(t *testing.T) {
+	e := New("", 100*time.Millisecond, log.NewNopLogger(), nil, WithTargets([]config.TargetConfig{
+		{
+			Match:  config.MustNewRegexp(`/run/memcached/(?P<tenant>[a-z]+)-(?P<shard>\d+)\.sock`),
+			Alias:  "$tenant-$shard",
+			Labels: map[string]string{"env": "prod"},
+		},
+	}))
+
+	if label := e.labeler("localhost:11211"); label != nil {
+		t.Fatal("expected no labeler for unmatched server")
+	}
+
+	label := e.labeler("/run/memcached/billing-3.sock")
+	if label == nil {
+		t.Fatal("expected labeler for matched server")
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectorFunc{
+		describe: e.Describe,
+		collect: func(ch chan<- prometheus.Metric) {
+			ch <- label(prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 1, "/run/memcached/billing-3.sock"))
+			ch <- label(prometheus.MustNewConstMetric(e.commands, prometheus.CounterValue, 5, "get", "hit", "/run/memcached/billing-3.sock"))
+		},
+	})
+
+	expected := `
+# HELP memcached_commands_total Total number of all requests broken down by command (get, set, etc.) and status.
+# TYPE memcached_commands_total counter
+memcached_commands_total{command="get",env="prod",server="billing-3",shard="3",status="hit",tenant="billing"} 5
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{env="prod",server="billing-3",shard="3",tenant="billing"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
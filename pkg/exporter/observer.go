@@ -0,0 +1,94 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tdewolff/memcached_exporter/connpool"
+)
+
+// Classes of errors returned by ErrorClass.
+const (
+	ErrorClassTimeout  = "timeout"
+	ErrorClassRefused  = "refused"
+	ErrorClassTLS      = "tls"
+	ErrorClassParse    = "parse"
+	ErrorClassProtocol = "protocol"
+	ErrorClassOther    = "other"
+)
+
+// Observer is notified about the collections of an Exporter.
+type Observer interface {
+	// ObserveCommand is called after a command was sent to a server. The
+	// command "connect" covers obtaining a connection to the server.
+	ObserveCommand(server, command string, duration time.Duration, err error)
+	// ObserveCollect is called after a server was collected, with the error
+	// that caused it to be reported as down, if any.
+	ObserveCollect(server string, duration time.Duration, err error)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveCommand(string, string, time.Duration, error) {}
+func (nopObserver) ObserveCollect(string, time.Duration, error)         {}
+
+// ParseError is returned when a value reported by a server cannot be parsed.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return "failed to parse stats: " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorClass returns the class of an error returned while collecting a
+// server: timeout, refused, tls, parse, protocol or other.
+func ErrorClass(err error) string {
+	var (
+		netError         net.Error
+		parseError       *ParseError
+		protocolError    *connpool.ProtocolError
+		recordError      tls.RecordHeaderError
+		authorityError   x509.UnknownAuthorityError
+		hostnameError    x509.HostnameError
+		certificateError x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &parseError):
+		return ErrorClassParse
+	case errors.As(err, &protocolError):
+		return ErrorClassProtocol
+	case errors.As(err, &recordError), errors.As(err, &authorityError),
+		errors.As(err, &hostnameError), errors.As(err, &certificateError),
+		err != nil && strings.Contains(err.Error(), "tls: "):
+		return ErrorClassTLS
+	case errors.As(err, &netError) && netError.Timeout():
+		return ErrorClassTimeout
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ENOENT):
+		return ErrorClassRefused
+	default:
+		return ErrorClassOther
+	}
+}
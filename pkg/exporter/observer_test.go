@@ -0,0 +1,100 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/connpool"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{&ParseError{Err: &strconv.NumError{Func: "ParseFloat", Num: "x", Err: strconv.ErrSyntax}}, ErrorClassParse},
+		{&connpool.ProtocolError{Command: "stats", Message: "unknown command"}, ErrorClassProtocol},
+		{fmt.Errorf("dial: %w", x509.UnknownAuthorityError{}), ErrorClassTLS},
+		{errors.New("remote error: tls: bad certificate"), ErrorClassTLS},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, ErrorClassTimeout},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ErrorClassRefused},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENOENT)}, ErrorClassRefused},
+		{errors.New("EOF"), ErrorClassOther},
+	}
+	for _, test := range tests {
+		if class := ErrorClass(test.err); class != test.class {
+			t.Errorf("ErrorClass(%v) = %q, want %q", test.err, class, test.class)
+		}
+	}
+}
+
+type recordingObserver struct {
+	commands []string
+	collects []error
+}
+
+func (o *recordingObserver) ObserveCommand(server, command string, duration time.Duration, err error) {
+	o.commands = append(o.commands, command)
+}
+
+func (o *recordingObserver) ObserveCollect(server string, duration time.Duration, err error) {
+	o.collects = append(o.collects, err)
+}
+
+func TestObserver(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		t.Parallel()
+
+		server := memcachedtest.NewServer(t, memcachedtest.Stats())
+		o := &recordingObserver{}
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithObserver(o))
+		testutil.CollectAndCount(e)
+
+		expected := []string{"connect", "stats", "stats slabs", "stats items", "stats settings"}
+		if !reflect.DeepEqual(o.commands, expected) {
+			t.Errorf("observed commands %q, want %q", o.commands, expected)
+		}
+		if len(o.collects) != 1 || o.collects[0] != nil {
+			t.Errorf("observed collects %v, want one successful collect", o.collects)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		t.Parallel()
+
+		stats := memcachedtest.Stats()
+		stats[""]["cmd_set"] = "invalid"
+		server := memcachedtest.NewServer(t, stats)
+		o := &recordingObserver{}
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithObserver(o))
+		testutil.CollectAndCount(e)
+
+		if len(o.collects) != 1 || ErrorClass(o.collects[0]) != ErrorClassParse {
+			t.Errorf("observed collects %v, want one parse error", o.collects)
+		}
+	})
+}
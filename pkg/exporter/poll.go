@@ -0,0 +1,181 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tdewolff/memcached_exporter/connpool"
+)
+
+// commandResult is the last successful answer of a server to a stats command.
+type commandResult struct {
+	time  time.Time
+	stats map[string]string
+}
+
+// cacheEntry is the result of collecting a server at a point in time.
+type cacheEntry struct {
+	time    time.Time
+	metrics []prometheus.Metric
+	stats   map[string]string
+}
+
+// WithPolling makes the exporter answer collections from a snapshot of each
+// server, which Poll refreshes every interval, instead of querying the servers
+// on every collection.
+func WithPolling(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.pollInterval = interval
+		e.snapshots = map[string]*cacheEntry{}
+	}
+}
+
+// WithCommandIntervals sets the minimum interval between two queries of the
+// given stats commands, keyed by their arguments, e.g. "slabs". In between,
+// the last answer of the server is reused.
+func WithCommandIntervals(intervals map[string]time.Duration) Option {
+	return func(e *Exporter) {
+		e.commandIntervals = intervals
+	}
+}
+
+// Poll refreshes the snapshot of every server every polling interval, until
+// ctx is done. Servers are polled independently, so that a slow server does
+// not delay the others. Servers added by SetAddresses are polled from then on.
+func (e *Exporter) Poll(ctx context.Context) {
+	var wg sync.WaitGroup
+	polling := map[string]context.CancelFunc{}
+	for {
+		current := map[string]bool{}
+		for _, address := range e.Addresses() {
+			current[address] = true
+			if polling[address] != nil {
+				continue
+			}
+			serverCtx, cancel := context.WithCancel(ctx)
+			polling[address] = cancel
+			wg.Add(1)
+			go func(server string) {
+				defer wg.Done()
+				e.poll(serverCtx, server)
+			}(address)
+		}
+		for address, cancel := range polling {
+			if !current[address] {
+				cancel()
+				delete(polling, address)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-e.addressesChanged:
+		}
+	}
+}
+
+// poll refreshes the snapshot of server every polling interval, until ctx is
+// done.
+func (e *Exporter) poll(ctx context.Context, server string) {
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+	for {
+		entry := e.fetchEntry(server, e.collectors)
+		// The server may have been removed meanwhile.
+		if ctx.Err() != nil {
+			return
+		}
+		e.mutex.Lock()
+		e.snapshots[server] = entry
+		e.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectSnapshot delivers the metrics of the collectors of set from the last
+// snapshot of server. Until the server was polled once, it is reported as down.
+func (e *Exporter) collectSnapshot(ch chan<- prometheus.Metric, server string, set collectorSet) map[string]string {
+	e.mutex.Lock()
+	entry := e.snapshots[server]
+	e.mutex.Unlock()
+	if entry == nil {
+		ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 0, server)
+		return nil
+	}
+
+	for _, m := range e.filterMetrics(entry.metrics, set) {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(e.lastCollect, prometheus.GaugeValue, float64(entry.time.UnixNano())/1e9, server)
+	return entry.stats
+}
+
+// fetchEntry queries server for the collectors of set and returns the resulting
+// metrics.
+func (e *Exporter) fetchEntry(server string, set collectorSet) *cacheEntry {
+	start := time.Now()
+	collected := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range collected {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	stats := e.fetchServer(collected, server, set)
+	close(collected)
+	<-done
+	return &cacheEntry{time: start, metrics: metrics, stats: stats}
+}
+
+// command issues the stats command with the given arguments on c, unless the
+// last answer is recent enough according to the command intervals.
+func (e *Exporter) command(c *connpool.Conn, args string) (map[string]string, error) {
+	interval := e.commandIntervals[args]
+	if interval > 0 {
+		e.mutex.Lock()
+		last, ok := e.lastCommands[c.Server()][args]
+		e.mutex.Unlock()
+		if ok && time.Since(last.time) < interval {
+			return last.stats, nil
+		}
+	}
+
+	start := time.Now()
+	stats, err := c.Stats(args)
+	e.observer.ObserveCommand(c.Server(), strings.TrimSpace("stats "+args), time.Since(start), err)
+
+	if interval > 0 && err == nil {
+		e.mutex.Lock()
+		if e.lastCommands[c.Server()] == nil {
+			e.lastCommands[c.Server()] = map[string]commandResult{}
+		}
+		e.lastCommands[c.Server()][args] = commandResult{time: start, stats: stats}
+		e.mutex.Unlock()
+	}
+	return stats, err
+}
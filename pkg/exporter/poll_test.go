@@ -0,0 +1,123 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestPoll(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e := New(server.Addr, time.Second, log.NewNopLogger(), nil,
+		WithPolling(10*time.Millisecond),
+		WithCommandIntervals(map[string]time.Duration{"slabs": time.Hour}),
+	)
+
+	// Until the first poll, the server is reported as down.
+	expected := `
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{server="SERVER"} 0
+`
+	expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Poll(ctx)
+		close(done)
+	}()
+	for testutil.CollectAndCount(e, "memcached_last_collect_timestamp_seconds") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Collections do not wait for a server that does not answer.
+	release := server.Hold()
+	if n := testutil.CollectAndCount(e, "memcached_up"); n != 1 {
+		t.Errorf("got %d memcached_up metrics, want 1", n)
+	}
+	release()
+	cancel()
+	<-done
+
+	general, slabs := 0, 0
+	for _, cmd := range server.Commands() {
+		switch cmd {
+		case "stats":
+			general++
+		case "stats slabs":
+			slabs++
+		}
+	}
+	if general < 2 {
+		t.Errorf("got %d general stats commands, want several", general)
+	}
+	if slabs != 1 {
+		t.Errorf("got %d slabs stats commands, want 1", slabs)
+	}
+}
+
+func TestPollSetAddresses(t *testing.T) {
+	a := memcachedtest.NewServer(t, memcachedtest.Stats())
+	b := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e := New(a.Addr, time.Second, log.NewNopLogger(), nil, WithPolling(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Poll(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for testutil.CollectAndCount(e, "memcached_last_collect_timestamp_seconds") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	e.SetAddresses([]string{b.Addr}, nil)
+	expected := `
+# HELP memcached_up Could the memcached server be reached.
+# TYPE memcached_up gauge
+memcached_up{server="B"} 1
+`
+	expected = strings.ReplaceAll(expected, "B", b.Addr)
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.CollectAndCompare(e, strings.NewReader(expected), "memcached_up") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal(testutil.CollectAndCompare(e, strings.NewReader(expected), "memcached_up"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The removed server is no longer polled.
+	time.Sleep(20 * time.Millisecond)
+	n := len(a.Commands())
+	time.Sleep(50 * time.Millisecond)
+	if m := len(a.Commands()); m != n {
+		t.Errorf("got %d commands on the removed server, want %d", m, n)
+	}
+}
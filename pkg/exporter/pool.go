@@ -0,0 +1,223 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+const (
+	// ketamaPointsPerServer is the average number of points libmemcached
+	// places on the continuum for each server with the weighted distribution,
+	// MEMCACHED_BEHAVIOR_KETAMA_WEIGHTED: 40 hashes of 4 points. Only this
+	// distribution is modelled; the unweighted one uses 100 points per server.
+	ketamaPointsPerServer = 160
+	// ketamaPointsPerHash is the number of points derived from a single MD5
+	// digest.
+	ketamaPointsPerHash = 4
+	// defaultPort is omitted from the server names hashed onto the continuum.
+	defaultPort = "11211"
+)
+
+// poolServer identifies a server within a pool.
+type poolServer struct {
+	pool   string
+	server string
+}
+
+// pool is a client pool configuration along with the expected share of the
+// keyspace of each of its servers.
+type pool struct {
+	config.PoolConfig
+	shares []float64
+}
+
+func newPool(cfg config.PoolConfig) pool {
+	p := pool{PoolConfig: cfg}
+	switch cfg.Hash {
+	case config.HashModula:
+		p.shares = modulaShares(cfg.Servers)
+	default:
+		p.shares = ketamaShares(cfg.Servers)
+	}
+	return p
+}
+
+// ketamaShares returns the fraction of the keyspace each server is assigned
+// on a weighted ketama continuum as built by libmemcached with
+// MEMCACHED_BEHAVIOR_KETAMA_WEIGHTED.
+func ketamaShares(servers []config.PoolServerConfig) []float64 {
+	type point struct {
+		value uint32
+		index int
+	}
+
+	var totalWeight float64
+	for _, s := range servers {
+		totalWeight += s.Weight
+	}
+
+	var points []point
+	for i, s := range servers {
+		pct := s.Weight / totalWeight
+		hashes := int(math.Floor(pct*ketamaPointsPerServer/ketamaPointsPerHash*float64(len(servers)) + 0.0000000001))
+		host := ketamaHost(s.Address)
+		for j := 0; j < hashes; j++ {
+			digest := md5.Sum([]byte(host + "-" + strconv.Itoa(j)))
+			for h := 0; h < ketamaPointsPerHash; h++ {
+				points = append(points, point{value: binary.LittleEndian.Uint32(digest[h*4:]), index: i})
+			}
+		}
+	}
+
+	shares := make([]float64, len(servers))
+	if len(points) == 0 {
+		return shares
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].value < points[j].value
+	})
+
+	// Every point owns the keys hashing between the previous point,
+	// exclusive, and itself, inclusive. The first point wraps around to the
+	// last one, which the unsigned subtraction takes care of.
+	prev := points[len(points)-1].value
+	for _, p := range points {
+		shares[p.index] += float64(p.value - prev)
+		prev = p.value
+	}
+	for i := range shares {
+		shares[i] /= math.MaxUint32 + 1
+	}
+	return shares
+}
+
+// ketamaHost returns the name under which libmemcached hashes a server onto
+// the continuum.
+func ketamaHost(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// Unix sockets have no port, which libmemcached treats as port 0.
+		return address + ":0"
+	}
+	if port == defaultPort {
+		return host
+	}
+	return host + ":" + port
+}
+
+// modulaShares returns the fraction of the keyspace each server is assigned
+// when keys are distributed by modulo over a server list in which every
+// server is repeated according to its weight.
+func modulaShares(servers []config.PoolServerConfig) []float64 {
+	var totalWeight float64
+	for _, s := range servers {
+		totalWeight += s.Weight
+	}
+	shares := make([]float64, len(servers))
+	for i, s := range servers {
+		shares[i] = s.Weight / totalWeight
+	}
+	return shares
+}
+
+// collectPool compares the expected share of the keyspace of each server of
+// the pool with its observed share of items, bytes and gets. stats holds the
+// general stats of every reachable server.
+func (e *Exporter) collectPool(ch chan<- prometheus.Metric, p *pool, stats map[string]map[string]string) {
+	for i, s := range p.Servers {
+		ch <- e.label(s.Address, prometheus.MustNewConstMetric(e.poolExpectedShare, prometheus.GaugeValue, p.shares[i], p.Name, s.Address))
+	}
+
+	observed := map[string][]float64{}
+	if items, ok := e.poolValues(p, stats, "curr_items"); ok {
+		observed["items"] = items
+	}
+	if bytes, ok := e.poolValues(p, stats, "bytes"); ok {
+		observed["bytes"] = bytes
+	}
+	if gets, ok := e.poolGets(p, stats); ok {
+		observed["gets"] = gets
+	}
+
+	for stat, values := range observed {
+		var total float64
+		for _, v := range values {
+			total += v
+		}
+		if total <= 0 {
+			continue
+		}
+		for i, s := range p.Servers {
+			if p.shares[i] == 0 {
+				continue
+			}
+			ratio := values[i] / total / p.shares[i]
+			ch <- e.label(s.Address, prometheus.MustNewConstMetric(e.poolImbalance, prometheus.GaugeValue, ratio, p.Name, s.Address, stat))
+		}
+	}
+}
+
+// poolValues returns the value of key for every server of the pool. It
+// reports false unless all servers could be collected.
+func (e *Exporter) poolValues(p *pool, stats map[string]map[string]string, key string) ([]float64, bool) {
+	values := make([]float64, len(p.Servers))
+	for i, s := range p.Servers {
+		v, err := parse(stats[s.Address], key, e.logger)
+		if err != nil {
+			level.Debug(e.logger).Log("msg", "Incomplete pool stats", "pool", p.Name, "server", s.Address, "key", key)
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+// poolGets returns the number of gets every server of the pool received since
+// the previous collection. It reports false unless all servers could be
+// collected both times and none of them restarted in between.
+func (e *Exporter) poolGets(p *pool, stats map[string]map[string]string) ([]float64, bool) {
+	gets, ok := e.poolValues(p, stats, "cmd_get")
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !ok {
+		for _, s := range p.Servers {
+			delete(e.lastGets, poolServer{p.Name, s.Address})
+		}
+		return nil, false
+	}
+
+	deltas := make([]float64, len(gets))
+	for i, s := range p.Servers {
+		key := poolServer{p.Name, s.Address}
+		last, seen := e.lastGets[key]
+		if !seen || gets[i] < last {
+			ok = false
+		}
+		deltas[i] = gets[i] - last
+		e.lastGets[key] = gets[i]
+	}
+	return deltas, ok
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+func TestKetamaShares(t *testing.T) {
+	t.Run("Equal weights", func(t *testing.T) {
+		t.Parallel()
+		shares := ketamaShares([]config.PoolServerConfig{
+			{Address: "10.0.0.1:11211", Weight: 1},
+			{Address: "10.0.0.2:11211", Weight: 1},
+			{Address: "10.0.0.3:11211", Weight: 1},
+			{Address: "10.0.0.4:11211", Weight: 1},
+		})
+		var total float64
+		for _, share := range shares {
+			total += share
+			if share < 0.15 || share > 0.35 {
+				t.Errorf("expected share close to 0.25, got %f", share)
+			}
+		}
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("expected shares to sum up to 1, got %f", total)
+		}
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		t.Parallel()
+		shares := ketamaShares([]config.PoolServerConfig{
+			{Address: "10.0.0.1:11211", Weight: 3},
+			{Address: "10.0.0.2:11211", Weight: 1},
+		})
+		if shares[0] < 0.65 || shares[0] > 0.85 {
+			t.Errorf("expected share close to 0.75, got %f", shares[0])
+		}
+		if math.Abs(shares[0]+shares[1]-1) > 1e-9 {
+			t.Errorf("expected shares to sum up to 1, got %f", shares[0]+shares[1])
+		}
+	})
+}
+
+func TestKetamaHost(t *testing.T) {
+	for address, want := range map[string]string{
+		"10.0.0.1:11211":       "10.0.0.1",
+		"10.0.0.1:11212":       "10.0.0.1:11212",
+		"/run/memcached.sock":  "/run/memcached.sock:0",
+		"[::1]:11211":          "::1",
+		"cache.example.com:80": "cache.example.com:80",
+	} {
+		if have := ketamaHost(address); have != want {
+			t.Errorf("want %q for %q, have %q", want, address, have)
+		}
+	}
+}
+
+func TestCollectPool(t *testing.T) {
+	e := New("a:11211,b:11211", 100*time.Millisecond, log.NewNopLogger(), nil, WithPools([]config.PoolConfig{
+		{
+			Name: "sessions",
+			Hash: config.HashModula,
+			Servers: []config.PoolServerConfig{
+				{Address: "a:11211", Weight: 1},
+				{Address: "b:11211", Weight: 3},
+			},
+		},
+		{
+			Name:    "unknown",
+			Hash:    config.HashModula,
+			Servers: []config.PoolServerConfig{{Address: "c:11211", Weight: 1}},
+		},
+	}))
+	if len(e.pools) != 1 {
+		t.Fatalf("expected pool with unknown servers to be skipped, got %d pools", len(e.pools))
+	}
+
+	collect := func(stats map[string]map[string]string) *prometheus.Registry {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectorFunc{
+			describe: e.Describe,
+			collect: func(ch chan<- prometheus.Metric) {
+				e.collectPool(ch, &e.pools[0], stats)
+			},
+		})
+		return registry
+	}
+
+	first := collect(map[string]map[string]string{
+		"a:11211": {"curr_items": "50", "bytes": "100", "cmd_get": "10"},
+		"b:11211": {"curr_items": "50", "bytes": "300", "cmd_get": "10"},
+	})
+	expected := `
+# HELP memcached_pool_expected_share Fraction of the keyspace the client pool is expected to assign to the server.
+# TYPE memcached_pool_expected_share gauge
+memcached_pool_expected_share{pool="sessions",server="a:11211"} 0.25
+memcached_pool_expected_share{pool="sessions",server="b:11211"} 0.75
+# HELP memcached_pool_imbalance_ratio Observed share of the pool's items, bytes or gets on the server divided by its expected share.
+# TYPE memcached_pool_imbalance_ratio gauge
+memcached_pool_imbalance_ratio{pool="sessions",server="a:11211",stat="bytes"} 1
+memcached_pool_imbalance_ratio{pool="sessions",server="a:11211",stat="items"} 2
+memcached_pool_imbalance_ratio{pool="sessions",server="b:11211",stat="bytes"} 1
+memcached_pool_imbalance_ratio{pool="sessions",server="b:11211",stat="items"} 0.6666666666666666
+`
+	if err := testutil.GatherAndCompare(first, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	second := collect(map[string]map[string]string{
+		"a:11211": {"curr_items": "50", "bytes": "100", "cmd_get": "30"},
+		"b:11211": {"curr_items": "50", "bytes": "300", "cmd_get": "70"},
+	})
+	expected = `
+# HELP memcached_pool_imbalance_ratio Observed share of the pool's items, bytes or gets on the server divided by its expected share.
+# TYPE memcached_pool_imbalance_ratio gauge
+memcached_pool_imbalance_ratio{pool="sessions",server="a:11211",stat="bytes"} 1
+memcached_pool_imbalance_ratio{pool="sessions",server="a:11211",stat="gets"} 1
+memcached_pool_imbalance_ratio{pool="sessions",server="a:11211",stat="items"} 2
+memcached_pool_imbalance_ratio{pool="sessions",server="b:11211",stat="bytes"} 1
+memcached_pool_imbalance_ratio{pool="sessions",server="b:11211",stat="gets"} 1
+memcached_pool_imbalance_ratio{pool="sessions",server="b:11211",stat="items"} 0.6666666666666666
+`
+	if err := testutil.GatherAndCompare(second, strings.NewReader(expected), "memcached_pool_imbalance_ratio"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+const subsystemProcess = "process"
+
+// processDescs are the descriptors of the metrics of the memcached processes.
+type processDescs struct {
+	cpu             *prometheus.Desc
+	residentMemory  *prometheus.Desc
+	virtualMemory   *prometheus.Desc
+	openFDs         *prometheus.Desc
+	maxFDs          *prometheus.Desc
+	startTime       *prometheus.Desc
+	threads         *prometheus.Desc
+	contextSwitches *prometheus.Desc
+}
+
+func newProcessDescs(newDesc func(string, string, []string, prometheus.Labels) *prometheus.Desc) processDescs {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return newDesc(prometheus.BuildFQName(Namespace, subsystemProcess, name), help, append(labels, "server"), nil)
+	}
+	return processDescs{
+		cpu:             desc("cpu_seconds_total", "Total user and system CPU time spent in seconds."),
+		residentMemory:  desc("resident_memory_bytes", "Resident memory size in bytes."),
+		virtualMemory:   desc("virtual_memory_bytes", "Virtual memory size in bytes."),
+		openFDs:         desc("open_fds", "Number of open file descriptors."),
+		maxFDs:          desc("max_fds", "Maximum number of open file descriptors."),
+		startTime:       desc("start_time_seconds", "Start time of the process since unix epoch in seconds."),
+		threads:         desc("threads", "Number of OS threads in the process."),
+		contextSwitches: desc("context_switches_total", "Number of context switches of the process by type.", "type"),
+	}
+}
+
+func (d processDescs) all() []*prometheus.Desc {
+	return []*prometheus.Desc{d.cpu, d.residentMemory, d.virtualMemory, d.openFDs, d.maxFDs, d.startTime, d.threads, d.contextSwitches}
+}
+
+// WithProcFS sets the mount point of the proc filesystem from which the
+// metrics of the memcached processes are read. It defaults to /proc.
+func WithProcFS(path string) Option {
+	return func(e *Exporter) {
+		e.procFS = path
+	}
+}
+
+// WithPIDFile sets the pid file of the server whose target has none, for the
+// process and cgroup collectors. It is ignored if the exporter has several
+// servers, which cannot share a process.
+func WithPIDFile(path string) Option {
+	return func(e *Exporter) {
+		e.defaultPIDFile = path
+	}
+}
+
+// pidFile returns the pid file pattern of the first target matching server,
+// expanded with the submatches of its match, or "" if it has none.
+func (e *Exporter) pidFile(server string) string {
+	for _, t := range e.targets {
+		if match := t.Match.FindStringSubmatchIndex(server); match != nil {
+			if t.PIDFile == "" {
+				return ""
+			}
+			return string(t.Match.ExpandString(nil, t.PIDFile, server, match))
+		}
+	}
+	return ""
+}
+
+// readPIDFile reads the pid from the file matching pattern. If several files
+// match, the most recently modified one is read.
+func readPIDFile(pattern string) (int, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		path   string
+		latest os.FileInfo
+	)
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if latest == nil || info.ModTime().After(latest.ModTime()) {
+			path, latest = match, info
+		}
+	}
+	if path == "" {
+		return 0, fmt.Errorf("no pid file matches %s", pattern)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid file %s: %w", path, err)
+	}
+	return pid, nil
+}
+
+// lookupPID returns the pid of the process of server, as set by SetAddresses
+// or read from the pid file of its target, or else from the default pid file
+// if server is the only server. It returns 0 if the pid is unknown.
+func (e *Exporter) lookupPID(server string) (int, error) {
+	e.mutex.Lock()
+	pid, ok := e.pids[server]
+	e.mutex.Unlock()
+	if ok {
+		return pid, nil
+	}
+	pattern := e.pidFile(server)
+	if pattern == "" && len(e.Addresses()) == 1 {
+		pattern = e.defaultPIDFile
+	}
+	if pattern == "" {
+		return 0, nil
+	}
+	return readPIDFile(pattern)
+}
+
+// collectProcess delivers the metrics of the process of server, if its pid is
+// known.
+func (e *Exporter) collectProcess(ch chan<- prometheus.Metric, server string) {
+	pid, err := e.lookupPID(server)
+	if err == nil && pid == 0 {
+		return
+	}
+	if err == nil {
+		err = e.readProcess(ch, server, pid)
+	}
+	if err != nil {
+		level.Warn(e.logger).Log("msg", "Failed to collect process metrics", "server", server, "err", err)
+	}
+}
+
+func (e *Exporter) readProcess(ch chan<- prometheus.Metric, server string, pid int) error {
+	fs, err := procfs.NewFS(e.procFS)
+	if err != nil {
+		return err
+	}
+	p, err := fs.Proc(pid)
+	if err != nil {
+		return err
+	}
+	stat, err := p.Stat()
+	if err != nil {
+		return err
+	}
+	d := e.process
+	ch <- prometheus.MustNewConstMetric(d.cpu, prometheus.CounterValue, stat.CPUTime(), server)
+	ch <- prometheus.MustNewConstMetric(d.residentMemory, prometheus.GaugeValue, float64(stat.ResidentMemory()), server)
+	ch <- prometheus.MustNewConstMetric(d.virtualMemory, prometheus.GaugeValue, float64(stat.VirtualMemory()), server)
+	ch <- prometheus.MustNewConstMetric(d.threads, prometheus.GaugeValue, float64(stat.NumThreads), server)
+	if start, err := stat.StartTime(); err == nil {
+		ch <- prometheus.MustNewConstMetric(d.startTime, prometheus.GaugeValue, start, server)
+	} else {
+		level.Debug(e.logger).Log("msg", "Failed to read process start time", "server", server, "err", err)
+	}
+
+	// The file descriptors are only readable by the owner of the process.
+	if fds, err := p.FileDescriptorsLen(); err == nil {
+		ch <- prometheus.MustNewConstMetric(d.openFDs, prometheus.GaugeValue, float64(fds), server)
+	} else {
+		level.Debug(e.logger).Log("msg", "Failed to read process file descriptors", "server", server, "err", err)
+	}
+	if limits, err := p.Limits(); err == nil {
+		ch <- prometheus.MustNewConstMetric(d.maxFDs, prometheus.GaugeValue, float64(limits.OpenFiles), server)
+	}
+	if status, err := p.NewStatus(); err == nil {
+		ch <- prometheus.MustNewConstMetric(d.contextSwitches, prometheus.CounterValue, float64(status.VoluntaryCtxtSwitches), "voluntary", server)
+		ch <- prometheus.MustNewConstMetric(d.contextSwitches, prometheus.CounterValue, float64(status.NonVoluntaryCtxtSwitches), "involuntary", server)
+	}
+	return nil
+}
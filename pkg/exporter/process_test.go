@@ -0,0 +1,169 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/internal/procfstest"
+)
+
+func TestProcess(t *testing.T) {
+	a := memcachedtest.NewServer(t, memcachedtest.Stats())
+	b := memcachedtest.NewServer(t, memcachedtest.Stats())
+	fs := procfstest.New(t)
+	fs.AddProcess(100, procfstest.Process{
+		Comm: "memcached", UTime: 150, STime: 50, StartTime: 1000, VSize: 1 << 30, RSS: 256,
+		Threads: 10, FDs: 3, MaxFDs: 1024, VoluntaryCtxtSwitches: 7, NonVoluntaryCtxtSwitches: 2,
+	})
+	pidDir := t.TempDir()
+	// The most recent pid file matching the glob is read.
+	for pid, file := range map[string]string{"99": "memcached-a.pid", "100": "memcached-a.pid.1"} {
+		if err := os.WriteFile(filepath.Join(pidDir, file), []byte(pid+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(pidDir, "memcached-a.pid"), old, old)
+
+	e := New(a.Addr+","+b.Addr, time.Second, log.NewNopLogger(), nil,
+		WithTargets([]config.TargetConfig{
+			{Match: config.MustNewRegexp(regexp.QuoteMeta(a.Addr)), Alias: "a", PIDFile: filepath.Join(pidDir, "memcached-a.pid*")},
+		}),
+		WithProcFS(fs.Path),
+	)
+	expected := `
+# HELP memcached_process_context_switches_total Number of context switches of the process by type.
+# TYPE memcached_process_context_switches_total counter
+memcached_process_context_switches_total{server="a",type="involuntary"} 2
+memcached_process_context_switches_total{server="a",type="voluntary"} 7
+# HELP memcached_process_cpu_seconds_total Total user and system CPU time spent in seconds.
+# TYPE memcached_process_cpu_seconds_total counter
+memcached_process_cpu_seconds_total{server="a"} 2
+# HELP memcached_process_max_fds Maximum number of open file descriptors.
+# TYPE memcached_process_max_fds gauge
+memcached_process_max_fds{server="a"} 1024
+# HELP memcached_process_open_fds Number of open file descriptors.
+# TYPE memcached_process_open_fds gauge
+memcached_process_open_fds{server="a"} 3
+# HELP memcached_process_resident_memory_bytes Resident memory size in bytes.
+# TYPE memcached_process_resident_memory_bytes gauge
+memcached_process_resident_memory_bytes{server="a"} RSS
+# HELP memcached_process_start_time_seconds Start time of the process since unix epoch in seconds.
+# TYPE memcached_process_start_time_seconds gauge
+memcached_process_start_time_seconds{server="a"} 1.70000001e+09
+# HELP memcached_process_threads Number of OS threads in the process.
+# TYPE memcached_process_threads gauge
+memcached_process_threads{server="a"} 10
+# HELP memcached_process_virtual_memory_bytes Virtual memory size in bytes.
+# TYPE memcached_process_virtual_memory_bytes gauge
+memcached_process_virtual_memory_bytes{server="a"} 1.073741824e+09
+`
+	expected = strings.ReplaceAll(expected, "RSS", strconv.Itoa(256*os.Getpagesize()))
+	names := []string{
+		"memcached_process_context_switches_total", "memcached_process_cpu_seconds_total",
+		"memcached_process_max_fds", "memcached_process_open_fds",
+		"memcached_process_resident_memory_bytes", "memcached_process_start_time_seconds",
+		"memcached_process_threads", "memcached_process_virtual_memory_bytes",
+	}
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	filtered, err := e.Filter([]string{CollectorGeneral})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(filtered, names...); n != 0 {
+		t.Errorf("want no process metrics without the process collector, got %d", n)
+	}
+}
+
+func TestPIDFile(t *testing.T) {
+	e := New("", time.Second, log.NewNopLogger(), nil, WithTargets([]config.TargetConfig{
+		{Match: config.MustNewRegexp(`/run/memcached/(?P<instance>\w+)\.sock`)},
+		{Match: config.MustNewRegexp(`.*:(\d+)`), PIDFile: "/run/memcached-$1.pid"},
+	}))
+	for server, want := range map[string]string{
+		"localhost:11211":              "/run/memcached-11211.pid",
+		"/run/memcached/sessions.sock": "",
+	} {
+		if got := e.pidFile(server); got != want {
+			t.Errorf("%s: want pid file %q, got %q", server, want, got)
+		}
+	}
+}
+
+func TestProcessPIDs(t *testing.T) {
+	a := memcachedtest.NewServer(t, memcachedtest.Stats())
+	b := memcachedtest.NewServer(t, memcachedtest.Stats())
+	fs := procfstest.New(t)
+	fs.AddProcess(100, procfstest.Process{Comm: "memcached", Threads: 4})
+	fs.AddProcess(200, procfstest.Process{Comm: "memcached", Threads: 8})
+
+	// The process metrics are described before the pids are known.
+	e := New("", time.Second, log.NewNopLogger(), nil, WithProcFS(fs.Path))
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+	e.SetAddresses([]string{a.Addr, b.Addr}, map[string]int{a.Addr: 100, b.Addr: 200})
+	expected := `
+# HELP memcached_process_threads Number of OS threads in the process.
+# TYPE memcached_process_threads gauge
+memcached_process_threads{server="A"} 4
+memcached_process_threads{server="B"} 8
+`
+	expected = strings.NewReplacer("A", a.Addr, "B", b.Addr).Replace(expected)
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "memcached_process_threads"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDefaultPIDFile(t *testing.T) {
+	a := memcachedtest.NewServer(t, memcachedtest.Stats())
+	fs := procfstest.New(t)
+	fs.AddProcess(100, procfstest.Process{Comm: "memcached", Threads: 4})
+	pidFile := filepath.Join(t.TempDir(), "memcached.pid")
+	if err := os.WriteFile(pidFile, []byte("100\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(a.Addr, time.Second, log.NewNopLogger(), nil, WithProcFS(fs.Path), WithPIDFile(pidFile))
+	expected := `
+# HELP memcached_process_threads Number of OS threads in the process.
+# TYPE memcached_process_threads gauge
+memcached_process_threads{server="A"} 4
+`
+	expected = strings.ReplaceAll(expected, "A", a.Addr)
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "memcached_process_threads"); err != nil {
+		t.Error(err)
+	}
+
+	// Several servers cannot share the process of the pid file.
+	b := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e = New(a.Addr+","+b.Addr, time.Second, log.NewNopLogger(), nil, WithProcFS(fs.Path), WithPIDFile(pidFile))
+	if n := testutil.CollectAndCount(e, "memcached_process_threads"); n != 0 {
+		t.Errorf("want no process metrics with several servers, got %d", n)
+	}
+}
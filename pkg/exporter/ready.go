@@ -0,0 +1,108 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// recordAnswer records that server answered a query started at t.
+func (e *Exporter) recordAnswer(server string, t time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if t.After(e.answered[server]) {
+		e.answered[server] = t
+	}
+}
+
+// Answering returns the number of servers that answered a collection or a
+// probe within interval, and the number of servers of the exporter. It only
+// reads the recorded answers, see Probe.
+func (e *Exporter) Answering(interval time.Duration) (answering, total int) {
+	addresses := e.Addresses()
+	since := time.Now().Add(-interval)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, address := range addresses {
+		if e.answered[address].After(since) {
+			answering++
+		}
+	}
+	return answering, len(addresses)
+}
+
+// Probe queries the servers that did not answer within half of interval, once
+// at start and then every interval until ctx is done, so that Answering does
+// not depend on the exporter being scraped. Servers answering collections are
+// not probed.
+func (e *Exporter) Probe(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		e.probeStale(time.Now().Add(-interval / 2))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeStale probes the servers that did not answer since the given time, at
+// most maxConcurrency at a time.
+func (e *Exporter) probeStale(since time.Time) {
+	var stale []string
+	addresses := e.Addresses()
+	e.mutex.Lock()
+	for _, address := range addresses {
+		if !e.answered[address].After(since) {
+			stale = append(stale, address)
+		}
+	}
+	e.mutex.Unlock()
+
+	n := len(stale)
+	if 0 < e.maxConcurrency && e.maxConcurrency < n {
+		n = e.maxConcurrency
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, n)
+	for _, address := range stale {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(server string) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			e.probe(server)
+		}(address)
+	}
+	wg.Wait()
+}
+
+// probe records whether server answers the general stats command.
+func (e *Exporter) probe(server string) {
+	start := time.Now()
+	c, err := e.connPool.Get(server)
+	e.observer.ObserveCommand(server, "connect", time.Since(start), err)
+	if err != nil {
+		return
+	}
+	_, err = e.command(c, "")
+	e.connPool.Put(c, err)
+	if err == nil {
+		e.recordAnswer(server, start)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestAnswering(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e := New(server.Addr+",127.0.0.1:1", time.Second, log.NewNopLogger(), nil)
+
+	// Answering does not query the servers.
+	if answering, total := e.Answering(time.Minute); answering != 0 || total != 2 {
+		t.Errorf("want 0 of 2 servers answering, got %d of %d", answering, total)
+	}
+	if n := len(server.Commands()); n != 0 {
+		t.Errorf("want no command, got %d", n)
+	}
+
+	// Servers that answered a collection are answering.
+	testutil.CollectAndCount(e)
+	if answering, _ := e.Answering(time.Minute); answering != 1 {
+		t.Errorf("want 1 server answering, got %d", answering)
+	}
+
+	server.Close()
+	if answering, _ := e.Answering(time.Minute); answering != 1 {
+		t.Errorf("want 1 server answering within a minute, got %d", answering)
+	}
+	if answering, _ := e.Answering(0); answering != 0 {
+		t.Errorf("want no server answering, got %d", answering)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	e := New(server.Addr+",127.0.0.1:1", time.Second, log.NewNopLogger(), nil)
+
+	// Servers that did not answer are probed.
+	e.probeStale(time.Now())
+	if answering, total := e.Answering(time.Minute); answering != 1 || total != 2 {
+		t.Errorf("want 1 of 2 servers answering, got %d of %d", answering, total)
+	}
+	if n := len(server.Commands()); n != 1 {
+		t.Errorf("want 1 command, got %d", n)
+	}
+
+	// Servers that answered since are not probed again.
+	e.probeStale(time.Now().Add(-time.Minute))
+	if n := len(server.Commands()); n != 1 {
+		t.Errorf("want no more commands, got %d", n-1)
+	}
+
+	// Probe probes at start, until ctx is done.
+	e = New(server.Addr, time.Second, log.NewNopLogger(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Probe(ctx, time.Hour)
+		close(done)
+	}()
+	for answering, _ := e.Answering(time.Minute); answering != 1; answering, _ = e.Answering(time.Minute) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/grobie/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Types of restarts. A warm restart restores the items from the memory file
+// given with -e, a cold restart starts with an empty cache.
+const (
+	RestartCold = "cold"
+	RestartWarm = "warm"
+)
+
+// serverStart is the start of a server as last observed by the exporter.
+type serverStart struct {
+	time     float64
+	uptime   float64
+	restarts map[string]float64
+}
+
+// startTime returns the time the server started, in seconds since the epoch,
+// computed from its general stats.
+func startTime(stats map[string]string) (float64, bool) {
+	uptime, err := strconv.ParseFloat(stats["uptime"], 64)
+	if err != nil {
+		return 0, false
+	}
+	now, err := strconv.ParseFloat(stats["time"], 64)
+	if err != nil {
+		return 0, false
+	}
+	return now - uptime, true
+}
+
+// withCreated returns a channel to which the metrics of a server are sent, and
+// which sets the created timestamp of the counters to start, the start of the
+// server, before forwarding them to ch. The returned function must be called
+// once all metrics were sent.
+func withCreated(ch chan<- prometheus.Metric, start float64) (chan<- prometheus.Metric, func()) {
+	created := timestamppb.New(time.Unix(int64(start), 0))
+	in := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range in {
+			ch <- &createdMetric{Metric: m, created: created}
+		}
+		close(done)
+	}()
+	return in, func() {
+		close(in)
+		<-done
+	}
+}
+
+// createdMetric sets the created timestamp of a counter.
+type createdMetric struct {
+	prometheus.Metric
+	created *timestamppb.Timestamp
+}
+
+func (m *createdMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	if out.Counter != nil && out.Counter.CreatedTimestamp == nil {
+		out.Counter.CreatedTimestamp = m.created
+	}
+	return nil
+}
+
+// observeStart returns the start time of server, if its general stats are
+// known, and counts the restarts. A restart is detected when the start time
+// moves forward or the uptime goes backwards. It is warm if the server was
+// started with a memory file and still holds items. The start time is kept
+// until the next restart, as it is computed from time and uptime, which are
+// reported in whole seconds.
+func (e *Exporter) observeStart(server string, stats map[net.Addr]memcache.Stats, settings map[net.Addr]map[string]string) (float64, bool) {
+	for addr, t := range stats {
+		start, ok := startTime(t.Stats)
+		if !ok {
+			return 0, false
+		}
+		uptime, _ := strconv.ParseFloat(t.Stats["uptime"], 64)
+
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		s, ok := e.starts[server]
+		if !ok {
+			s = &serverStart{time: start, restarts: map[string]float64{RestartCold: 0, RestartWarm: 0}}
+			e.starts[server] = s
+		}
+		if start > s.time+1 || uptime < s.uptime {
+			restart := RestartCold
+			if items, _ := strconv.ParseFloat(t.Stats["curr_items"], 64); settings[addr]["memory_file"] != "" && items > 0 {
+				restart = RestartWarm
+			}
+			s.restarts[restart]++
+			s.time = start
+		}
+		s.uptime = uptime
+		return s.time, true
+	}
+	return 0, false
+}
+
+// collectStart delivers the start time of server and the number of restarts
+// the exporter observed.
+func (e *Exporter) collectStart(ch chan<- prometheus.Metric, server string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	s, ok := e.starts[server]
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.startTime, prometheus.GaugeValue, s.time, server)
+	for _, restart := range []string{RestartCold, RestartWarm} {
+		ch <- prometheus.MustNewConstMetric(e.restarts, prometheus.CounterValue, s.restarts[restart], restart, server)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestRestarts(t *testing.T) {
+	stats := memcachedtest.Stats()
+	stats[""]["time"] = "1700003600"
+	server := memcachedtest.NewServer(t, stats)
+	e := New(server.Addr, time.Second, log.NewNopLogger(), nil)
+
+	names := []string{"memcached_start_time_seconds", "memcached_restarts_total"}
+	expected := `
+# HELP memcached_restarts_total Number of restarts of the server observed by the exporter, by type of restart.
+# TYPE memcached_restarts_total counter
+memcached_restarts_total{server="SERVER",type="cold"} COLD
+memcached_restarts_total{server="SERVER",type="warm"} WARM
+# HELP memcached_start_time_seconds UNIX time at which the server started, computed from its time and uptime.
+# TYPE memcached_start_time_seconds gauge
+memcached_start_time_seconds{server="SERVER"} START
+`
+	compare := func(start, cold, warm string) {
+		t.Helper()
+		r := strings.NewReplacer("SERVER", server.Addr, "START", start, "COLD", cold, "WARM", warm)
+		if err := testutil.CollectAndCompare(e, strings.NewReader(r.Replace(expected)), names...); err != nil {
+			t.Error(err)
+		}
+	}
+	compare("1.7e+09", "0", "0")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	checkCreated := func() {
+		t.Helper()
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() != "memcached_uptime_seconds" {
+				continue
+			}
+			if created := mf.Metric[0].GetCounter().GetCreatedTimestamp().AsTime(); !created.Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("want created timestamp 1700000000, got %v", created.Unix())
+			}
+		}
+	}
+	checkCreated()
+
+	// The start time of a running server is kept, although time and uptime
+	// are reported in whole seconds.
+	stats[""]["time"] = "1700003661"
+	stats[""]["uptime"] = "3660"
+	server.SetStats("", stats[""])
+	compare("1.7e+09", "0", "0")
+	checkCreated()
+
+	stats[""]["time"] = "1700003700"
+	stats[""]["uptime"] = "10"
+	server.SetStats("", stats[""])
+	compare("1.70000369e+09", "1", "0")
+
+	stats[""]["time"] = "1700003800"
+	stats[""]["uptime"] = "5"
+	stats[""]["curr_items"] = "3"
+	server.SetStats("", stats[""])
+	server.SetStats("settings", map[string]string{"maxconns": "1024", "memory_file": "/dev/shm/memcached"})
+	compare("1.700003795e+09", "1", "1")
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instanceSettings are settings that identify a server rather than configure
+// it, so they are expected to differ between servers.
+var instanceSettings = map[string]bool{
+	"inter":         true,
+	"tcpport":       true,
+	"udpport":       true,
+	"domain_socket": true,
+	"memory_file":   true,
+}
+
+// Settings queries the settings of server, with its version as the setting
+// "version".
+func (e *Exporter) Settings(server string) (map[string]string, error) {
+	start := time.Now()
+	c, err := e.connPool.Get(server)
+	e.observer.ObserveCommand(server, "connect", time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := e.command(c, "settings")
+	var stats map[string]string
+	if err == nil {
+		stats, err = e.command(c, "")
+	}
+	e.connPool.Put(c, err)
+	if err != nil {
+		return nil, err
+	}
+	return withVersion(settings, stats), nil
+}
+
+// withVersion returns a copy of settings with the version from the general
+// stats.
+func withVersion(settings, stats map[string]string) map[string]string {
+	s := make(map[string]string, len(settings)+1)
+	for k, v := range settings {
+		s[k] = v
+	}
+	if version, ok := stats["version"]; ok {
+		s["version"] = version
+	}
+	return s
+}
+
+// DiffSettings returns the sorted names of the settings whose values differ
+// between servers, given the settings of each server. A setting missing on
+// some servers differs. Settings that identify a server, like its port, are
+// not compared.
+func DiffSettings(settings map[string]map[string]string) []string {
+	values := map[string]map[string]bool{}
+	for _, s := range settings {
+		for name, value := range s {
+			if values[name] == nil {
+				values[name] = map[string]bool{}
+			}
+			values[name][value] = true
+		}
+	}
+	var diff []string
+	for name, vs := range values {
+		if instanceSettings[name] {
+			continue
+		}
+		for _, s := range settings {
+			if _, ok := s[name]; !ok {
+				vs[""] = true
+			}
+		}
+		if len(vs) > 1 {
+			diff = append(diff, name)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// recordSettings records the settings of server, or forgets them if it is
+// down.
+func (e *Exporter) recordSettings(server string, settings map[string]string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if settings == nil {
+		delete(e.settings, server)
+		return
+	}
+	e.settings[server] = settings
+}
+
+// collectSettingsDrift delivers whether each setting differs between the
+// servers whose settings are known.
+func (e *Exporter) collectSettingsDrift(ch chan<- prometheus.Metric) {
+	addresses := e.Addresses()
+	e.mutex.Lock()
+	settings := make(map[string]map[string]string, len(addresses))
+	for _, address := range addresses {
+		if s, ok := e.settings[address]; ok {
+			settings[address] = s
+		}
+	}
+	e.mutex.Unlock()
+
+	drift := map[string]bool{}
+	for _, name := range DiffSettings(settings) {
+		drift[name] = true
+	}
+	seen := map[string]bool{}
+	for _, s := range settings {
+		for name := range s {
+			if seen[name] || instanceSettings[name] {
+				continue
+			}
+			seen[name] = true
+			v := 0.0
+			if drift[name] {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(e.settingsDrift, prometheus.GaugeValue, v, name)
+		}
+	}
+}
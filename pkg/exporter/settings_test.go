@@ -0,0 +1,79 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+func TestDiffSettings(t *testing.T) {
+	diff := DiffSettings(map[string]map[string]string{
+		"a:11211": {"maxconns": "1024", "evictions": "on", "item_size_max": "1048576", "tcpport": "11211", "version": "1.6.21"},
+		"b:11211": {"maxconns": "1024", "evictions": "off", "tcpport": "11212", "version": "1.6.22"},
+	})
+	if want := []string{"evictions", "item_size_max", "version"}; !reflect.DeepEqual(diff, want) {
+		t.Errorf("want %v, got %v", want, diff)
+	}
+}
+
+func TestSettingsDrift(t *testing.T) {
+	statsA := memcachedtest.Stats()
+	statsA[""]["version"] = "1.6.21"
+	statsA["settings"]["evictions"] = "on"
+	a := memcachedtest.NewServer(t, statsA)
+	statsB := memcachedtest.Stats()
+	statsB[""]["version"] = "1.6.21"
+	statsB["settings"]["evictions"] = "off"
+	b := memcachedtest.NewServer(t, statsB)
+
+	e := New(a.Addr+","+b.Addr, time.Second, log.NewNopLogger(), nil)
+	expected := `
+# HELP memcached_settings_drift Whether the setting differs between the servers of the exporter.
+# TYPE memcached_settings_drift gauge
+memcached_settings_drift{setting="evictions"} 1
+memcached_settings_drift{setting="maxconns"} 0
+memcached_settings_drift{setting="version"} 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "memcached_settings_drift"); err != nil {
+		t.Error(err)
+	}
+
+	settings, err := e.Settings(b.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"maxconns": "1024", "evictions": "off", "version": "1.6.21"}; !reflect.DeepEqual(settings, want) {
+		t.Errorf("want settings %v, got %v", want, settings)
+	}
+
+	// The settings of a server that is down are not compared.
+	b.Close()
+	expected = `
+# HELP memcached_settings_drift Whether the setting differs between the servers of the exporter.
+# TYPE memcached_settings_drift gauge
+memcached_settings_drift{setting="evictions"} 0
+memcached_settings_drift{setting="maxconns"} 0
+memcached_settings_drift{setting="version"} 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "memcached_settings_drift"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grobie/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+// WithModule sets the module whose options apply to all servers, overriding
+// those of the target configurations.
+func WithModule(name string, cfg config.ModuleConfig) Option {
+	return func(e *Exporter) {
+		e.module = name
+		e.moduleConfig = cfg
+	}
+}
+
+// slabsConfig returns the slab options of server, from the module or else from
+// the first target matching server, or nil if there are none.
+func (e *Exporter) slabsConfig(server string) *config.SlabsConfig {
+	if e.moduleConfig.Slabs != nil {
+		return e.moduleConfig.Slabs
+	}
+	for _, t := range e.targets {
+		if t.Match.MatchString(server) {
+			return t.Slabs
+		}
+	}
+	return nil
+}
+
+// newBucketDescs creates the descriptors of the per-slab-class metrics with a
+// size_bucket label instead of the slab label.
+func (e *Exporter) newBucketDescs(newDesc func(string, string, []string, prometheus.Labels) *prometheus.Desc, specs map[*prometheus.Desc]descSpec) map[*prometheus.Desc]*prometheus.Desc {
+	descs := map[*prometheus.Desc]*prometheus.Desc{}
+	for _, name := range []string{CollectorSlabs, CollectorItems} {
+		for _, desc := range e.collectorDescs[name] {
+			spec := specs[desc]
+			labels := make([]string, len(spec.variableLabels))
+			slab := false
+			for i, label := range spec.variableLabels {
+				if label == "slab" {
+					label = "size_bucket"
+					slab = true
+				}
+				labels[i] = label
+			}
+			if slab {
+				descs[desc] = newDesc(spec.fqName, spec.help, labels, spec.constLabels)
+			}
+		}
+	}
+	return descs
+}
+
+// bucketSample is the aggregate of the samples of the slab classes in a size
+// bucket.
+type bucketSample struct {
+	desc        *prometheus.Desc
+	valueType   prometheus.ValueType
+	value       float64
+	labelValues []string
+}
+
+// slabFilter decides which samples of the per-slab-class metrics of a server
+// are dropped, kept or aggregated, according to the slab options of the server.
+type slabFilter struct {
+	drop map[string]bool
+	// keep holds the slab classes kept, or is nil if all are.
+	keep map[string]bool
+	// buckets holds the size bucket of each slab class, or is nil if the
+	// samples are not aggregated.
+	buckets map[string]string
+}
+
+// newSlabFilter returns the slab filter of server for stats, or nil if server
+// has no slab options.
+func (e *Exporter) newSlabFilter(server string, stats map[net.Addr]memcache.Stats) *slabFilter {
+	cfg := e.slabsConfig(server)
+	if cfg == nil {
+		return nil
+	}
+	f := &slabFilter{drop: map[string]bool{}}
+	for _, name := range cfg.Drop {
+		f.drop[name] = true
+	}
+	for _, t := range stats {
+		if cfg.MaxClasses > 0 {
+			f.keep = largestSlabs(t, cfg.MaxClasses)
+		}
+		if len(cfg.SizeBuckets) > 0 {
+			f.buckets = sizeBuckets(t, cfg.SizeBuckets)
+		}
+	}
+	return f
+}
+
+// sample returns the labels of the sample of m, a per-slab-class metric, and
+// whether f keeps it. Samples that are aggregated are labelled with their
+// size_bucket.
+func (e *Exporter) sample(f *slabFilter, m prometheus.Metric) (*dto.Metric, map[string]string, bool) {
+	if f.drop[e.specs[m.Desc()].fqName] {
+		return nil, nil, false
+	}
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		return nil, nil, false
+	}
+	labels := map[string]string{}
+	for _, lp := range out.Label {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	if f.keep != nil && !f.keep[labels["slab"]] {
+		return nil, nil, false
+	}
+	if f.buckets != nil {
+		bucket, ok := f.buckets[labels["slab"]]
+		if !ok {
+			return nil, nil, false
+		}
+		labels["size_bucket"] = bucket
+	}
+	return &out, labels, true
+}
+
+// filterSlabs returns a channel to which the per-slab-class metrics of server
+// are sent, and which drops, caps or aggregates them according to the slab
+// options of server before forwarding them to ch. The returned function must
+// be called once all metrics were sent.
+func (e *Exporter) filterSlabs(ch chan<- prometheus.Metric, server string, stats map[net.Addr]memcache.Stats) (chan<- prometheus.Metric, func()) {
+	f := e.newSlabFilter(server, stats)
+	if f == nil {
+		return ch, func() {}
+	}
+	maxDescs := map[*prometheus.Desc]bool{
+		e.slabsChunkSize:     true,
+		e.slabsChunksPerPage: true,
+		e.itemsAge:           true,
+		e.itemsAgeOldestHot:  true,
+		e.itemsAgeOldestWarm: true,
+		e.itemsEvictedTime:   true,
+	}
+
+	var keys []string
+	samples := map[string]*bucketSample{}
+	in := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range in {
+			bucketDesc, ok := e.bucketDescs[m.Desc()]
+			if !ok {
+				ch <- m
+				continue
+			}
+			out, labels, ok := e.sample(f, m)
+			if !ok {
+				continue
+			}
+			if f.buckets == nil {
+				ch <- m
+				continue
+			}
+
+			spec := e.specs[m.Desc()]
+			values := make([]string, len(spec.variableLabels))
+			for i, label := range spec.variableLabels {
+				if label == "slab" {
+					label = "size_bucket"
+				}
+				values[i] = labels[label]
+			}
+			valueType, value := prometheus.GaugeValue, out.GetGauge().GetValue()
+			if out.Counter != nil {
+				valueType, value = prometheus.CounterValue, out.GetCounter().GetValue()
+			}
+
+			key := spec.fqName + "\x00" + strings.Join(values, "\x00")
+			s, ok := samples[key]
+			switch {
+			case !ok:
+				keys = append(keys, key)
+				samples[key] = &bucketSample{desc: bucketDesc, valueType: valueType, value: value, labelValues: values}
+			case maxDescs[m.Desc()]:
+				s.value = math.Max(s.value, value)
+			default:
+				s.value += value
+			}
+		}
+	}()
+
+	return in, func() {
+		close(in)
+		<-done
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := samples[key]
+			ch <- prometheus.MustNewConstMetric(s.desc, s.valueType, s.value, s.labelValues...)
+		}
+	}
+}
+
+// largestSlabs returns the n slab classes storing the most items.
+func largestSlabs(stats memcache.Stats, n int) map[string]bool {
+	counts := map[int]float64{}
+	for slab, v := range stats.Slabs {
+		if used, err := strconv.ParseFloat(v["used_chunks"], 64); err == nil {
+			counts[slab] = used
+		}
+	}
+	for slab, v := range stats.Items {
+		if number, err := strconv.ParseFloat(v["number"], 64); err == nil {
+			counts[slab] = number
+		}
+	}
+
+	slabs := make([]int, 0, len(counts))
+	for slab := range counts {
+		slabs = append(slabs, slab)
+	}
+	sort.Slice(slabs, func(i, j int) bool {
+		if counts[slabs[i]] != counts[slabs[j]] {
+			return counts[slabs[i]] > counts[slabs[j]]
+		}
+		return slabs[i] < slabs[j]
+	})
+	if len(slabs) > n {
+		slabs = slabs[:n]
+	}
+
+	keep := make(map[string]bool, len(slabs))
+	for _, slab := range slabs {
+		keep[strconv.Itoa(slab)] = true
+	}
+	return keep
+}
+
+// sizeBuckets returns the size bucket of each slab class, named by its upper
+// bound, based on the chunk size of the class.
+func sizeBuckets(stats memcache.Stats, bounds []uint64) map[string]string {
+	buckets := map[string]string{}
+	for slab, v := range stats.Slabs {
+		size, err := strconv.ParseUint(v["chunk_size"], 10, 64)
+		if err != nil {
+			continue
+		}
+		bucket := "+Inf"
+		for _, bound := range bounds {
+			if size <= bound {
+				bucket = strconv.FormatUint(bound, 10)
+				break
+			}
+		}
+		buckets[strconv.Itoa(slab)] = bucket
+	}
+	return buckets
+}
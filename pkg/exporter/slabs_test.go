@@ -0,0 +1,146 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+)
+
+// slabStats returns stats with three slab classes of increasing chunk size,
+// storing 5, 20 and 10 items.
+func slabStats() map[string]map[string]string {
+	stats := memcachedtest.Stats()
+	stats["slabs"] = map[string]string{
+		"1:chunk_size":   "96",
+		"1:cmd_set":      "5",
+		"1:cas_hits":     "0",
+		"1:cas_badval":   "0",
+		"2:chunk_size":   "120",
+		"2:cmd_set":      "20",
+		"2:cas_hits":     "0",
+		"2:cas_badval":   "0",
+		"3:chunk_size":   "1184",
+		"3:cmd_set":      "10",
+		"3:cas_hits":     "0",
+		"3:cas_badval":   "0",
+		"active_slabs":   "3",
+		"total_malloced": "3145728",
+	}
+	stats["items"] = map[string]string{
+		"items:1:number": "5",
+		"items:2:number": "20",
+		"items:3:number": "10",
+	}
+	return stats
+}
+
+func TestSlabs(t *testing.T) {
+	names := []string{"memcached_slab_chunk_size_bytes", "memcached_slab_current_items"}
+
+	t.Run("Drop", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, slabStats())
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithTargets([]config.TargetConfig{{
+			Match: config.MustNewRegexp(".*"),
+			Slabs: &config.SlabsConfig{Drop: []string{"memcached_slab_chunk_size_bytes"}},
+		}}))
+
+		expected := `
+# HELP memcached_slab_current_items Number of items currently stored in this slab class.
+# TYPE memcached_slab_current_items gauge
+memcached_slab_current_items{server="SERVER",slab="1"} 5
+memcached_slab_current_items{server="SERVER",slab="2"} 20
+memcached_slab_current_items{server="SERVER",slab="3"} 10
+`
+		expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("MaxClasses", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, slabStats())
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithModule("top", config.ModuleConfig{
+			Slabs: &config.SlabsConfig{MaxClasses: 2},
+		}))
+
+		expected := `
+# HELP memcached_slab_chunk_size_bytes Number of bytes allocated to each chunk within this slab class.
+# TYPE memcached_slab_chunk_size_bytes gauge
+memcached_slab_chunk_size_bytes{server="SERVER",slab="2"} 120
+memcached_slab_chunk_size_bytes{server="SERVER",slab="3"} 1184
+# HELP memcached_slab_current_items Number of items currently stored in this slab class.
+# TYPE memcached_slab_current_items gauge
+memcached_slab_current_items{server="SERVER",slab="2"} 20
+memcached_slab_current_items{server="SERVER",slab="3"} 10
+`
+		expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("SizeBuckets", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, slabStats())
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil, WithModule("buckets", config.ModuleConfig{
+			Slabs: &config.SlabsConfig{SizeBuckets: []uint64{128, 1024}},
+		}))
+
+		expected := `
+# HELP memcached_slab_chunk_size_bytes Number of bytes allocated to each chunk within this slab class.
+# TYPE memcached_slab_chunk_size_bytes gauge
+memcached_slab_chunk_size_bytes{server="SERVER",size_bucket="+Inf"} 1184
+memcached_slab_chunk_size_bytes{server="SERVER",size_bucket="128"} 120
+# HELP memcached_slab_current_items Number of items currently stored in this slab class.
+# TYPE memcached_slab_current_items gauge
+memcached_slab_current_items{server="SERVER",size_bucket="+Inf"} 10
+memcached_slab_current_items{server="SERVER",size_bucket="128"} 25
+`
+		expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("SizeBucketsWithoutSlabs", func(t *testing.T) {
+		t.Parallel()
+		server := memcachedtest.NewServer(t, slabStats())
+		e := New(server.Addr, time.Second, log.NewNopLogger(), nil,
+			WithCollectors(CollectorItems),
+			WithModule("buckets", config.ModuleConfig{
+				Slabs: &config.SlabsConfig{SizeBuckets: []uint64{128}},
+			}),
+		)
+
+		expected := `
+# HELP memcached_slab_current_items Number of items currently stored in this slab class.
+# TYPE memcached_slab_current_items gauge
+memcached_slab_current_items{server="SERVER",size_bucket="+Inf"} 10
+memcached_slab_current_items{server="SERVER",size_bucket="128"} 25
+`
+		expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+			t.Error(err)
+		}
+	})
+}
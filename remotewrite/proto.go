@@ -0,0 +1,166 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Label is a label of a time series.
+type Label struct {
+	Name, Value string
+}
+
+// Sample is a sample of a time series, with its timestamp in milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries is a time series of a remote-write request.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Field numbers of the remote-write protobuf messages.
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// marshalWriteRequest encodes series as a prometheus.WriteRequest message.
+func marshalWriteRequest(series []TimeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, labelName, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, labelValue, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+			tsb = protowire.AppendTag(tsb, timeSeriesLabels, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+		for _, s := range ts.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, sampleValue, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+			sb = protowire.AppendTag(sb, sampleTimestamp, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+			tsb = protowire.AppendTag(tsb, timeSeriesSamples, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, sb)
+		}
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsb)
+	}
+	return b
+}
+
+// timeSeries converts the gathered metric families to time series, using the
+// timestamp of a metric if set, or else now, in milliseconds. The external
+// labels are added to series that do not have a label of the same name.
+func timeSeries(mfs []*dto.MetricFamily, externalLabels map[string]string, now int64) []TimeSeries {
+	var series []TimeSeries
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			t := now
+			if m.TimestampMs != nil {
+				t = m.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...Label) {
+				labels := map[string]string{model.MetricNameLabel: name}
+				// Labels with an empty value are the same as missing ones.
+				for _, lp := range m.Label {
+					if lp.GetValue() != "" {
+						labels[lp.GetName()] = lp.GetValue()
+					}
+				}
+				for _, l := range extra {
+					labels[l.Name] = l.Value
+				}
+				for name, value := range externalLabels {
+					if _, ok := labels[name]; !ok {
+						labels[name] = value
+					}
+				}
+				series = append(series, TimeSeries{
+					Labels:  sortedLabels(labels),
+					Samples: []Sample{{Value: value, Timestamp: t}},
+				})
+			}
+
+			name := mf.GetName()
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.Bucket {
+					inf = math.IsInf(b.GetUpperBound(), 1)
+					add(name+"_bucket", float64(b.GetCumulativeCount()), Label{model.BucketLabel, formatFloat(b.GetUpperBound())})
+				}
+				if !inf {
+					add(name+"_bucket", float64(h.GetSampleCount()), Label{model.BucketLabel, "+Inf"})
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, q.GetValue(), Label{model.QuantileLabel, formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// sortedLabels returns the labels sorted by name, as remote-write requires.
+func sortedLabels(labels map[string]string) []Label {
+	sorted := make([]Label, 0, len(labels))
+	for name, value := range labels {
+		sorted = append(sorted, Label{Name: name, Value: value})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotewrite sends the metrics of a Prometheus gatherer to a
+// Prometheus remote-write endpoint.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+// request is an encoded remote-write request waiting to be sent.
+type request struct {
+	body    []byte
+	samples int
+}
+
+// recoverableError is an error after which a request is sent again.
+type recoverableError struct {
+	error
+}
+
+// Sender periodically collects the metrics of a gatherer and sends them to a
+// remote-write endpoint. Requests that fail with a recoverable error are
+// retried with exponential backoff, while the following requests wait in a
+// bounded queue.
+type Sender struct {
+	url            string
+	gatherer       prometheus.Gatherer
+	logger         log.Logger
+	client         *http.Client
+	externalLabels map[string]string
+	maxQueued      int
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	now            func() time.Time
+
+	mutex  sync.Mutex
+	queue  []request
+	notify chan struct{}
+
+	samples    *prometheus.CounterVec
+	retries    prometheus.Counter
+	queueLen   prometheus.GaugeFunc
+	lastSend   prometheus.Gauge
+	sendErrors prometheus.Counter
+}
+
+// Option configures a Sender.
+type Option func(*Sender)
+
+// WithClient sets the HTTP client of the requests, which handles the
+// authentication and TLS settings.
+func WithClient(client *http.Client) Option {
+	return func(s *Sender) {
+		s.client = client
+	}
+}
+
+// WithExternalLabels sets labels added to all series that do not already
+// have a label of the same name.
+func WithExternalLabels(labels map[string]string) Option {
+	return func(s *Sender) {
+		s.externalLabels = labels
+	}
+}
+
+// WithMaxQueued sets the maximum number of requests waiting to be sent,
+// beyond which the oldest requests are dropped. It defaults to 100.
+func WithMaxQueued(n int) Option {
+	return func(s *Sender) {
+		s.maxQueued = n
+	}
+}
+
+// WithBackoff sets the minimum and maximum time between two attempts to send
+// a request. It defaults to 1s and 1m.
+func WithBackoff(min, max time.Duration) Option {
+	return func(s *Sender) {
+		s.minBackoff = min
+		s.maxBackoff = max
+	}
+}
+
+// New returns a Sender sending the metrics of gatherer to url.
+func New(url string, gatherer prometheus.Gatherer, logger log.Logger, opts ...Option) *Sender {
+	s := &Sender{
+		url:        url,
+		gatherer:   gatherer,
+		logger:     logger,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		maxQueued:  100,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+		now:        time.Now,
+		notify:     make(chan struct{}, 1),
+		samples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "memcached_exporter_remote_write_samples_total",
+			Help: "Number of samples collected for remote write, by result: sent, dropped because of a full queue, or rejected by the endpoint.",
+		}, []string{"result"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "memcached_exporter_remote_write_retries_total",
+			Help: "Number of remote-write requests sent again after a recoverable error.",
+		}),
+		lastSend: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "memcached_exporter_remote_write_last_send_timestamp_seconds",
+			Help: "Time at which a remote-write request was last sent successfully.",
+		}),
+		sendErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "memcached_exporter_remote_write_errors_total",
+			Help: "Number of failed attempts to send a remote-write request.",
+		}),
+	}
+	s.queueLen = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "memcached_exporter_remote_write_queue_length",
+		Help: "Number of remote-write requests waiting to be sent.",
+	}, func() float64 {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return float64(len(s.queue))
+	})
+	for _, result := range []string{"sent", "dropped", "rejected"} {
+		s.samples.WithLabelValues(result)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Describe implements prometheus.Collector.
+func (s *Sender) Describe(ch chan<- *prometheus.Desc) {
+	s.samples.Describe(ch)
+	s.retries.Describe(ch)
+	s.queueLen.Describe(ch)
+	s.lastSend.Describe(ch)
+	s.sendErrors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *Sender) Collect(ch chan<- prometheus.Metric) {
+	s.samples.Collect(ch)
+	s.retries.Collect(ch)
+	s.queueLen.Collect(ch)
+	s.lastSend.Collect(ch)
+	s.sendErrors.Collect(ch)
+}
+
+// Run collects the metrics at every interval and sends them until ctx is
+// done.
+func (s *Sender) Run(ctx context.Context, interval time.Duration) {
+	go s.send(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Enqueue(); err != nil {
+			level.Error(s.logger).Log("msg", "Failed to collect metrics for remote write", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Enqueue collects the metrics and queues them as a request, dropping the
+// oldest request if the queue is full.
+func (s *Sender) Enqueue() error {
+	mfs, err := s.gatherer.Gather()
+	series := timeSeries(mfs, s.externalLabels, s.now().UnixMilli())
+	if len(series) == 0 {
+		return err
+	}
+	r := request{
+		body:    snappy.Encode(nil, marshalWriteRequest(series)),
+		samples: len(series),
+	}
+
+	s.mutex.Lock()
+	if len(s.queue) >= s.maxQueued {
+		level.Warn(s.logger).Log("msg", "Remote-write queue is full, dropping the oldest request", "samples", s.queue[0].samples)
+		s.samples.WithLabelValues("dropped").Add(float64(s.queue[0].samples))
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, r)
+	s.mutex.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// send sends the queued requests in order until ctx is done.
+func (s *Sender) send(ctx context.Context) {
+	for {
+		s.mutex.Lock()
+		if len(s.queue) == 0 {
+			s.mutex.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			}
+			continue
+		}
+		r := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		if err := s.sendWithRetries(ctx, r); err != nil && ctx.Err() == nil {
+			level.Error(s.logger).Log("msg", "Remote-write endpoint rejected request", "samples", r.samples, "err", err)
+			s.samples.WithLabelValues("rejected").Add(float64(r.samples))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// sendWithRetries sends r until it succeeds, fails with an unrecoverable
+// error, or ctx is done.
+func (s *Sender) sendWithRetries(ctx context.Context, r request) error {
+	backoff := s.minBackoff
+	for {
+		err := s.sendRequest(ctx, r)
+		if err == nil {
+			s.samples.WithLabelValues("sent").Add(float64(r.samples))
+			s.lastSend.SetToCurrentTime()
+			return nil
+		}
+		s.sendErrors.Inc()
+		var recoverable recoverableError
+		if !errors.As(err, &recoverable) {
+			return err
+		}
+		level.Warn(s.logger).Log("msg", "Failed to send remote-write request, retrying", "backoff", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		s.retries.Inc()
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// sendRequest sends r once. Network errors, server errors and rate limiting
+// are recoverable.
+func (s *Sender) sendRequest(ctx context.Context, r request) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(r.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "memcached_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
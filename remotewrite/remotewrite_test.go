@@ -0,0 +1,260 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	promconfig "github.com/prometheus/common/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// unmarshalWriteRequest decodes a prometheus.WriteRequest message.
+func unmarshalWriteRequest(t *testing.T, b []byte) []TimeSeries {
+	t.Helper()
+	// fields calls f with the number and value of each field of the message
+	// b, where the value of a varint or fixed64 field is in v.
+	fields := func(b []byte, f func(num protowire.Number, v uint64, data []byte)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			switch typ {
+			case protowire.BytesType:
+				data, n := protowire.ConsumeBytes(b)
+				f(num, 0, data)
+				b = b[n:]
+			case protowire.Fixed64Type:
+				v, n := protowire.ConsumeFixed64(b)
+				f(num, v, nil)
+				b = b[n:]
+			case protowire.VarintType:
+				v, n := protowire.ConsumeVarint(b)
+				f(num, v, nil)
+				b = b[n:]
+			default:
+				t.Fatalf("unexpected wire type %v", typ)
+			}
+		}
+	}
+
+	var series []TimeSeries
+	fields(b, func(_ protowire.Number, _ uint64, tsb []byte) {
+		var ts TimeSeries
+		fields(tsb, func(num protowire.Number, _ uint64, data []byte) {
+			switch num {
+			case timeSeriesLabels:
+				var l Label
+				fields(data, func(num protowire.Number, _ uint64, data []byte) {
+					if num == labelName {
+						l.Name = string(data)
+					} else {
+						l.Value = string(data)
+					}
+				})
+				ts.Labels = append(ts.Labels, l)
+			case timeSeriesSamples:
+				var s Sample
+				fields(data, func(num protowire.Number, v uint64, _ []byte) {
+					if num == sampleValue {
+						s.Value = math.Float64frombits(v)
+					} else {
+						s.Timestamp = int64(v)
+					}
+				})
+				ts.Samples = append(ts.Samples, s)
+			}
+		})
+		series = append(series, ts)
+	})
+	return series
+}
+
+// receive starts a remote-write receiver answering with the given statuses in
+// turn, and returns its URL and a channel of the received series.
+func receive(t *testing.T, statuses ...int) (string, <-chan []TimeSeries, <-chan *http.Request) {
+	t.Helper()
+	series := make(chan []TimeSeries, len(statuses))
+	requests := make(chan *http.Request, len(statuses))
+	i := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		b, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Error(err)
+		}
+		status := statuses[len(statuses)-1]
+		if i < len(statuses) {
+			status = statuses[i]
+		}
+		i++
+		series <- unmarshalWriteRequest(t, b)
+		requests <- r
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, series, requests
+}
+
+func testSender(t *testing.T, url string, opts ...Option) *Sender {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "memcached_up", Help: "Up."}, []string{"server", "site"})
+	up.WithLabelValues("localhost:11211", "").Set(1)
+	up.WithLabelValues("localhost:11212", "core").Set(0)
+	registry.MustRegister(up)
+
+	s := New(url, registry, log.NewNopLogger(), opts...)
+	s.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	return s
+}
+
+func TestSender(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		t.Parallel()
+		url, series, requests := receive(t, http.StatusNoContent)
+		client, err := promconfig.NewClientFromConfig(promconfig.HTTPClientConfig{
+			BasicAuth: &promconfig.BasicAuth{Username: "edge", Password: "secret"},
+		}, "remote_write")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := testSender(t, url, WithClient(client), WithExternalLabels(map[string]string{"site": "edge-1"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.Run(ctx, time.Hour)
+
+		want := []TimeSeries{
+			{
+				Labels:  []Label{{"__name__", "memcached_up"}, {"server", "localhost:11211"}, {"site", "edge-1"}},
+				Samples: []Sample{{1, 1700000000000}},
+			},
+			{
+				Labels:  []Label{{"__name__", "memcached_up"}, {"server", "localhost:11212"}, {"site", "core"}},
+				Samples: []Sample{{0, 1700000000000}},
+			},
+		}
+		if got := <-series; !reflect.DeepEqual(got, want) {
+			t.Errorf("want series %v, got %v", want, got)
+		}
+		r := <-requests
+		if user, password, ok := r.BasicAuth(); !ok || user != "edge" || password != "secret" {
+			t.Errorf("want basic auth, got %q %q", user, password)
+		}
+		for name, want := range map[string]string{
+			"Content-Encoding":                  "snappy",
+			"Content-Type":                      "application/x-protobuf",
+			"X-Prometheus-Remote-Write-Version": "0.1.0",
+		} {
+			if got := r.Header.Get(name); got != want {
+				t.Errorf("want header %s %q, got %q", name, want, got)
+			}
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		t.Parallel()
+		url, series, _ := receive(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+		s := testSender(t, url, WithBackoff(time.Millisecond, time.Millisecond))
+		if err := s.Enqueue(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.sendWithRetries(context.Background(), s.queue[0]); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(series); n != 3 {
+			t.Errorf("want 3 requests, got %d", n)
+		}
+		if n := testutil.ToFloat64(s.retries); n != 2 {
+			t.Errorf("want 2 retries, got %v", n)
+		}
+		if n := testutil.ToFloat64(s.samples.WithLabelValues("sent")); n != 2 {
+			t.Errorf("want 2 samples sent, got %v", n)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		t.Parallel()
+		url, series, _ := receive(t, http.StatusBadRequest)
+		s := testSender(t, url, WithBackoff(time.Millisecond, time.Millisecond))
+		if err := s.Enqueue(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.sendWithRetries(context.Background(), s.queue[0]); err == nil {
+			t.Error("expected error on HTTP status 400")
+		}
+		if n := len(series); n != 1 {
+			t.Errorf("want 1 request, got %d", n)
+		}
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		t.Parallel()
+		s := testSender(t, "http://localhost:0", WithMaxQueued(2))
+		for i := 0; i < 3; i++ {
+			if err := s.Enqueue(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := testutil.ToFloat64(s.queueLen); n != 2 {
+			t.Errorf("want 2 queued requests, got %v", n)
+		}
+		if n := testutil.ToFloat64(s.samples.WithLabelValues("dropped")); n != 2 {
+			t.Errorf("want 2 dropped samples, got %v", n)
+		}
+	})
+}
+
+func TestTimeSeries(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "test_duration_seconds",
+		Help:    "Test histogram.",
+		Buckets: []float64{0.5},
+	})
+	histogram.Observe(0.1)
+	histogram.Observe(1)
+	registry.MustRegister(histogram)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TimeSeries{
+		{Labels: []Label{{"__name__", "test_duration_seconds_bucket"}, {"le", "0.5"}}, Samples: []Sample{{1, 1000}}},
+		{Labels: []Label{{"__name__", "test_duration_seconds_bucket"}, {"le", "+Inf"}}, Samples: []Sample{{2, 1000}}},
+		{Labels: []Label{{"__name__", "test_duration_seconds_sum"}}, Samples: []Sample{{1.1, 1000}}},
+		{Labels: []Label{{"__name__", "test_duration_seconds_count"}}, Samples: []Sample{{2, 1000}}},
+	}
+	if got := timeSeries(mfs, nil, 1000); !reflect.DeepEqual(got, want) {
+		t.Errorf("want series %v, got %v", want, got)
+	}
+}
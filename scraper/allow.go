@@ -0,0 +1,199 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+// Reasons for rejecting a target.
+const (
+	rejectInvalid    = "invalid"
+	rejectUnixSocket = "unix_socket"
+	rejectPort       = "port"
+	rejectHostname   = "hostname"
+	rejectAddress    = "address"
+)
+
+// RejectedError is returned for targets that are not allowed.
+type RejectedError struct {
+	Target string
+	Reason string
+	Err    error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("target %q is not allowed: %v", e.Target, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Allowlist restricts the targets that may be scraped, see config.AllowConfig.
+type Allowlist struct {
+	cfg    config.AllowConfig
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewAllowlist returns the allowlist described by cfg.
+func NewAllowlist(cfg config.AllowConfig) *Allowlist {
+	return &Allowlist{cfg: cfg, lookup: net.DefaultResolver.LookupIPAddr}
+}
+
+// Check returns a RejectedError if target, a memcached address, is not
+// allowed. The addresses a hostname resolves to must be allowed as well.
+func (a *Allowlist) Check(ctx context.Context, target string) error {
+	reject := func(reason string, format string, args ...interface{}) error {
+		return &RejectedError{Target: target, Reason: reason, Err: fmt.Errorf(format, args...)}
+	}
+
+	if strings.Contains(target, "/") {
+		if !a.unixSocketAllowed(target) {
+			return reject(rejectUnixSocket, "unix socket outside of the allowed directories")
+		}
+		return nil
+	}
+
+	host, port, err := splitHostPort(target)
+	if err != nil {
+		return reject(rejectInvalid, "%v", err)
+	}
+	if !a.portAllowed(port) {
+		return reject(rejectPort, "port %d is not allowed", port)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if len(a.cfg.CIDRs) == 0 || !a.ipAllowed(ip) {
+			return reject(rejectAddress, "address %s is not allowed", ip)
+		}
+		return nil
+	}
+	if !a.hostnameAllowed(host) {
+		return reject(rejectHostname, "hostname %q is not allowed", host)
+	}
+	if len(a.cfg.CIDRs) > 0 {
+		addrs, err := a.lookup(ctx, host)
+		if err != nil {
+			return reject(rejectAddress, "%v", err)
+		}
+		for _, addr := range addrs {
+			if !a.ipAllowed(addr.IP) {
+				return reject(rejectAddress, "hostname %q resolves to %s, which is not allowed", host, addr.IP)
+			}
+		}
+	}
+	return nil
+}
+
+// Control checks the resolved address of a connection before it is
+// established, so that a hostname cannot resolve to a different address than
+// the one checked by Check. It can be used as net.Dialer.Control.
+func (a *Allowlist) Control(network, address string, c syscall.RawConn) error {
+	if strings.HasPrefix(network, "unix") {
+		if !a.unixSocketAllowed(address) {
+			return &RejectedError{Target: address, Reason: rejectUnixSocket, Err: fmt.Errorf("unix socket outside of the allowed directories")}
+		}
+		return nil
+	}
+
+	host, port, err := splitHostPort(address)
+	if err != nil {
+		return &RejectedError{Target: address, Reason: rejectInvalid, Err: err}
+	}
+	if !a.portAllowed(port) {
+		return &RejectedError{Target: address, Reason: rejectPort, Err: fmt.Errorf("port %d is not allowed", port)}
+	}
+	if ip := net.ParseIP(host); len(a.cfg.CIDRs) > 0 && (ip == nil || !a.ipAllowed(ip)) {
+		return &RejectedError{Target: address, Reason: rejectAddress, Err: fmt.Errorf("address %s is not allowed", host)}
+	}
+	return nil
+}
+
+// unixSocketAllowed reports whether the socket at path is located in one of
+// the allowed directories once symbolic links are resolved, so that a link in
+// an allowed directory cannot point to a socket elsewhere. Paths that do not
+// exist, such as globs, are compared as given; the sockets they lead to are
+// checked again by Control when connecting.
+func (a *Allowlist) unixSocketAllowed(path string) bool {
+	path = evalSymlinks(path)
+	for _, prefix := range a.cfg.UnixSocketPrefixes {
+		prefix = evalSymlinks(prefix)
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Allowlist) portAllowed(port uint16) bool {
+	if len(a.cfg.Ports) == 0 {
+		return true
+	}
+	for _, r := range a.cfg.Ports {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Allowlist) hostnameAllowed(host string) bool {
+	if len(a.cfg.Hostnames) == 0 {
+		return true
+	}
+	for _, re := range a.cfg.Hostnames {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Allowlist) ipAllowed(ip net.IP) bool {
+	for _, cidr := range a.cfg.CIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitHostPort(address string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, uint16(port), nil
+}
+
+// evalSymlinks returns path with its symbolic links resolved, or cleaned if it
+// cannot be resolved.
+func evalSymlinks(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tdewolff/memcached_exporter/config"
+)
+
+func testAllowlist() *Allowlist {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	a := NewAllowlist(config.AllowConfig{
+		CIDRs:              []config.CIDR{{IPNet: private}},
+		Hostnames:          []config.Regexp{config.MustNewRegexp(`memcached-\d+\.internal`)},
+		Ports:              []config.PortRange{{Min: 11211, Max: 11219}},
+		UnixSocketPrefixes: []string{"/run/memcached"},
+	})
+	a.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "memcached-1.internal":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+		case "memcached-2.internal":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}, {IP: net.ParseIP("169.254.169.254")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return a
+}
+
+func TestAllowlistCheck(t *testing.T) {
+	a := testAllowlist()
+	tests := []struct {
+		target string
+		reason string
+	}{
+		{"10.1.2.3:11211", ""},
+		{"10.1.2.3:11219", ""},
+		{"memcached-1.internal:11211", ""},
+		{"/run/memcached/memcached.sock", ""},
+		{"/run/memcached/../docker.sock", rejectUnixSocket},
+		{"/run/memcached-other/memcached.sock", rejectUnixSocket},
+		{"10.1.2.3:22", rejectPort},
+		{"192.168.0.1:11211", rejectAddress},
+		{"[::1]:11211", rejectAddress},
+		{"memcached-2.internal:11211", rejectAddress},
+		{"memcached-3.internal:11211", rejectAddress},
+		{"metadata.google.internal:11211", rejectHostname},
+		{"10.1.2.3", rejectInvalid},
+		{"10.1.2.3:http", rejectInvalid},
+	}
+	for _, test := range tests {
+		err := a.Check(context.Background(), test.target)
+		var rejected *RejectedError
+		switch {
+		case test.reason == "" && err != nil:
+			t.Errorf("target %q was rejected: %v", test.target, err)
+		case test.reason != "" && !errors.As(err, &rejected):
+			t.Errorf("target %q was allowed, want it rejected", test.target)
+		case test.reason != "" && rejected.Reason != test.reason:
+			t.Errorf("target %q was rejected because of %q, want %q", test.target, rejected.Reason, test.reason)
+		}
+	}
+}
+
+func TestAllowlistControl(t *testing.T) {
+	a := testAllowlist()
+	tests := []struct {
+		network, address string
+		allowed          bool
+	}{
+		{"tcp4", "10.0.0.1:11211", true},
+		{"tcp4", "169.254.169.254:11211", false},
+		{"tcp4", "10.0.0.1:80", false},
+		{"unix", "/run/memcached/memcached.sock", true},
+		{"unix", "/var/run/docker.sock", false},
+	}
+	for _, test := range tests {
+		err := a.Control(test.network, test.address, nil)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("Control(%q, %q) = %v, want allowed %v", test.network, test.address, err, test.allowed)
+		}
+	}
+}
+
+func TestAllowlistUnixSocketSymlink(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "memcached")
+	outside := filepath.Join(dir, "docker.sock")
+	if err := os.Mkdir(allowed, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{outside, filepath.Join(allowed, "memcached.sock")} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(allowed, "link.sock")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAllowlist(config.AllowConfig{UnixSocketPrefixes: []string{allowed}})
+	if err := a.Check(context.Background(), filepath.Join(allowed, "memcached.sock")); err != nil {
+		t.Errorf("socket in allowed directory was rejected: %v", err)
+	}
+	if err := a.Check(context.Background(), link); err == nil {
+		t.Errorf("link to socket outside of allowed directory was allowed")
+	}
+	if err := a.Control("unix", link, nil); err == nil {
+		t.Errorf("link to socket outside of allowed directory was allowed when connecting")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/log/level"
+)
+
+// DebugHandler serves the raw output of the stats commands issued to the
+// servers of the target parameter as JSON, with whether each key is exported
+// as a metric, skipped, or failed to parse, given the options of the module
+// parameter. It waits for the concurrency limits like Handler.
+func (s *Scraper) DebugHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		module := r.URL.Query().Get("module")
+		if !s.checkRequest(w, r, target, module) {
+			return
+		}
+
+		if err := s.limiter.acquire(r.Context(), target); err != nil {
+			s.rejectQueued(w, target, err)
+			return
+		}
+		e := s.exporterFor(target, module, scrapeObserver{Scraper: s, known: s.known(target)})
+		stats := e.DebugStats()
+		s.limiter.release(target)
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			level.Error(s.logger).Log("msg", "Failed to write debug stats", "err", err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errQueueFull is returned when a collection cannot start right away and too
+// many collections are already waiting.
+var errQueueFull = errors.New("too many concurrent scrapes")
+
+// limiter bounds the number of concurrent collections, in total and per
+// target. Collections that cannot start right away wait in a bounded queue.
+// A limit of zero means no limit.
+type limiter struct {
+	maxConcurrent          int
+	maxConcurrentPerTarget int
+	maxQueued              int
+
+	mutex   sync.Mutex
+	running int
+	targets map[string]int
+	queued  int
+	changed chan struct{}
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		targets: map[string]int{},
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire waits until a collection of target may start, or returns
+// errQueueFull if the queue is full, or the error of ctx if it is done first.
+// Collections that started must call release.
+func (l *limiter) acquire(ctx context.Context, target string) error {
+	l.mutex.Lock()
+	queued := false
+	for {
+		if l.canRun(target) {
+			l.running++
+			l.targets[target]++
+			if queued {
+				l.queued--
+			}
+			l.mutex.Unlock()
+			return nil
+		}
+		if !queued {
+			if l.queued >= l.maxQueued {
+				l.mutex.Unlock()
+				return errQueueFull
+			}
+			l.queued++
+			queued = true
+		}
+		changed := l.changed
+		l.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			l.mutex.Lock()
+			l.queued--
+			l.mutex.Unlock()
+			return ctx.Err()
+		}
+		l.mutex.Lock()
+	}
+}
+
+// release ends a collection of target started with acquire.
+func (l *limiter) release(target string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.running--
+	l.targets[target]--
+	if l.targets[target] <= 0 {
+		delete(l.targets, target)
+	}
+	// Wake up the waiting collections, so that they check whether they may
+	// start now.
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *limiter) canRun(target string) bool {
+	return (l.maxConcurrent == 0 || l.running < l.maxConcurrent) &&
+		(l.maxConcurrentPerTarget == 0 || l.targets[target] < l.maxConcurrentPerTarget)
+}
+
+// stats returns the number of running and queued collections.
+func (l *limiter) stats() (running, queued int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.running, l.queued
+}
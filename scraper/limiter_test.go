@@ -0,0 +1,77 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("Per target", func(t *testing.T) {
+		t.Parallel()
+
+		l := newLimiter()
+		l.maxConcurrentPerTarget = 1
+		l.maxQueued = 1
+		ctx := context.Background()
+
+		if err := l.acquire(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.acquire(ctx, "b"); err != nil {
+			t.Fatalf("other target was limited: %v", err)
+		}
+
+		acquired := make(chan error)
+		go func() {
+			acquired <- l.acquire(ctx, "a")
+		}()
+		for _, queued := l.stats(); queued != 1; _, queued = l.stats() {
+			time.Sleep(time.Millisecond)
+		}
+		if err := l.acquire(ctx, "a"); err != errQueueFull {
+			t.Errorf("got error %v with a full queue, want %v", err, errQueueFull)
+		}
+
+		l.release("a")
+		if err := <-acquired; err != nil {
+			t.Errorf("queued collection failed to start: %v", err)
+		}
+		if running, queued := l.stats(); running != 2 || queued != 0 {
+			t.Errorf("got %d running and %d queued collections, want 2 and 0", running, queued)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		t.Parallel()
+
+		l := newLimiter()
+		l.maxConcurrent = 1
+		l.maxQueued = 1
+
+		if err := l.acquire(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := l.acquire(ctx, "b"); err != context.DeadlineExceeded {
+			t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		if _, queued := l.stats(); queued != 0 {
+			t.Errorf("got %d queued collections after cancellation, want 0", queued)
+		}
+	})
+}
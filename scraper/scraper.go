@@ -14,15 +14,27 @@
 package scraper
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/internal/exposition"
 	"github.com/tdewolff/memcached_exporter/pkg/exporter"
+	"golang.org/x/sync/singleflight"
 )
 
 type Scraper struct {
@@ -30,47 +42,465 @@ type Scraper struct {
 	timeout   time.Duration
 	tlsConfig *tls.Config
 
-	scrapeCount  prometheus.Counter
-	scrapeErrors prometheus.Counter
+	exporterOptions []exporter.Option
+	modules         map[string]config.ModuleConfig
+	targets         []config.TargetConfig
+	staticTargets   map[string]bool
+	createdSamples  bool
+
+	scrapeCount     *prometheus.CounterVec
+	scrapeErrors    *prometheus.CounterVec
+	scrapeDuration  *prometheus.HistogramVec
+	commandDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	coalesced       *prometheus.CounterVec
+	running         prometheus.GaugeFunc
+	queued          prometheus.GaugeFunc
+	rejected        *prometheus.CounterVec
+	targetsRejected *prometheus.CounterVec
+
+	group     singleflight.Group
+	limiter   *limiter
+	allowlist *Allowlist
+
+	// flights are the requests waiting for the shared collection of a key.
+	flightsMutex sync.Mutex
+	flights      map[string]*flight
+}
+
+// Option configures a Scraper.
+type Option func(*Scraper)
+
+// WithLimits bounds the number of targets collected at the same time, in total
+// and per target. Scrapes exceeding the limits wait in a queue of at most
+// maxQueued scrapes, and are rejected once it is full. A limit of zero means no
+// limit.
+func WithLimits(maxConcurrent, maxConcurrentPerTarget, maxQueued int) Option {
+	return func(s *Scraper) {
+		s.limiter.maxConcurrent = maxConcurrent
+		s.limiter.maxConcurrentPerTarget = maxConcurrentPerTarget
+		s.limiter.maxQueued = maxQueued
+	}
 }
 
-func New(timeout time.Duration, logger log.Logger, tlsConfig *tls.Config) *Scraper {
+// WithAllowlist restricts the targets that may be scraped. Rejected targets
+// are answered with 403 Forbidden.
+func WithAllowlist(a *Allowlist) Option {
+	return func(s *Scraper) {
+		s.allowlist = a
+	}
+}
+
+// WithExporterOptions sets the options of the exporters created for each
+// scraped target.
+func WithExporterOptions(opts ...exporter.Option) Option {
+	return func(s *Scraper) {
+		s.exporterOptions = append(s.exporterOptions, opts...)
+	}
+}
+
+// WithTargets sets the configured targets. The scrapes of targets matching
+// them are recorded under their own target label.
+func WithTargets(targets []config.TargetConfig) Option {
+	return func(s *Scraper) {
+		s.targets = targets
+	}
+}
+
+// WithStaticTargets sets the addresses collected by the exporter itself, such
+// as those of --memcached.address. Their scrapes are recorded under their own
+// target label.
+func WithStaticTargets(addresses []string) Option {
+	return func(s *Scraper) {
+		for _, address := range addresses {
+			s.staticTargets[address] = true
+		}
+	}
+}
+
+// WithModules sets the modules that scrapes may select with the module
+// parameter.
+func WithModules(modules map[string]config.ModuleConfig) Option {
+	return func(s *Scraper) {
+		s.modules = modules
+	}
+}
+
+// WithCreatedSamples serves the created timestamps of counters, summaries and
+// histograms as _created samples to clients accepting OpenMetrics.
+func WithCreatedSamples(enabled bool) Option {
+	return func(s *Scraper) {
+		s.createdSamples = enabled
+	}
+}
+
+func New(timeout time.Duration, logger log.Logger, tlsConfig *tls.Config, opts ...Option) *Scraper {
 	level.Debug(logger).Log("msg", "Started scrapper")
-	return &Scraper{
-		logger:    logger,
-		timeout:   timeout,
-		tlsConfig: tlsConfig,
-		scrapeCount: prometheus.NewCounter(prometheus.CounterOpts{
+	l := newLimiter()
+	s := &Scraper{
+		logger:        logger,
+		timeout:       timeout,
+		tlsConfig:     tlsConfig,
+		limiter:       l,
+		flights:       map[string]*flight{},
+		staticTargets: map[string]bool{},
+		scrapeCount: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "memcached_exporter_scrapes_total",
 			Help: "Count of memcached exporter scapes.",
-		}),
-		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+		}, []string{"target"}),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "memcached_exporter_scrape_errors_total",
-			Help: "Count of memcached exporter scape errors.",
+			Help: "Count of memcached exporter scape errors by class of error.",
+		}, []string{"target", "class"}),
+		scrapeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "memcached_exporter_scrape_duration_seconds",
+			Help:    "Duration of memcached exporter scrapes.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"target"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "memcached_exporter_command_duration_seconds",
+			Help:    "Duration of the commands sent to memcached by the exporter.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"target", "command"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "memcached_exporter_scrapes_in_flight",
+			Help: "Number of memcached exporter scrapes currently in progress.",
 		}),
+		coalesced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "memcached_exporter_scrapes_coalesced_total",
+			Help: "Count of scrape requests served by the collection of a concurrent request for the same target and module.",
+		}, []string{"target"}),
+		running: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "memcached_exporter_collections_running",
+			Help: "Number of target collections currently running.",
+		}, func() float64 {
+			running, _ := l.stats()
+			return float64(running)
+		}),
+		queued: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "memcached_exporter_collections_queued",
+			Help: "Number of target collections waiting for the concurrency limits.",
+		}, func() float64 {
+			_, queued := l.stats()
+			return float64(queued)
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "memcached_exporter_scrapes_rejected_total",
+			Help: "Count of scrape requests rejected because the queue of the concurrency limits was full or the request was canceled while queued.",
+		}, []string{"reason"}),
+		targetsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "memcached_exporter_targets_rejected_total",
+			Help: "Count of scrape requests rejected because the target is missing or not allowed.",
+		}, []string{"reason"}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Describe implements prometheus.Collector.
+func (s *Scraper) Describe(ch chan<- *prometheus.Desc) {
+	s.scrapeCount.Describe(ch)
+	s.scrapeErrors.Describe(ch)
+	s.scrapeDuration.Describe(ch)
+	s.commandDuration.Describe(ch)
+	s.inFlight.Describe(ch)
+	s.coalesced.Describe(ch)
+	s.running.Describe(ch)
+	s.queued.Describe(ch)
+	s.rejected.Describe(ch)
+	s.targetsRejected.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *Scraper) Collect(ch chan<- prometheus.Metric) {
+	s.scrapeCount.Collect(ch)
+	s.scrapeErrors.Collect(ch)
+	s.scrapeDuration.Collect(ch)
+	s.commandDuration.Collect(ch)
+	s.inFlight.Collect(ch)
+	s.coalesced.Collect(ch)
+	s.running.Collect(ch)
+	s.queued.Collect(ch)
+	s.rejected.Collect(ch)
+	s.targetsRejected.Collect(ch)
+}
+
+// ObserveCommand implements exporter.Observer.
+func (s *Scraper) ObserveCommand(target, command string, duration time.Duration, err error) {
+	s.commandDuration.WithLabelValues(target, command).Observe(duration.Seconds())
+}
+
+// ObserveCollect implements exporter.Observer.
+func (s *Scraper) ObserveCollect(target string, duration time.Duration, err error) {
+	s.scrapeCount.WithLabelValues(target).Inc()
+	s.scrapeDuration.WithLabelValues(target).Observe(duration.Seconds())
+	if err != nil {
+		s.scrapeErrors.WithLabelValues(target, exporter.ErrorClass(err)).Inc()
 	}
 }
 
+// otherTarget is the target label of the scrapes of targets that are neither
+// configured nor static, so that clients cannot add series without limit.
+const otherTarget = "other"
+
+// rejectMissing is the reason for rejecting requests without a target.
+const rejectMissing = "missing"
+
+// known reports whether the scrapes of target are recorded under their own
+// target label, which is the case if it is a static target or matches a
+// configured target. Targets listing several addresses are not known, as any
+// combination of them could be requested.
+func (s *Scraper) known(target string) bool {
+	if s.staticTargets[target] {
+		return true
+	}
+	if strings.Contains(target, ",") {
+		return false
+	}
+	for _, t := range s.targets {
+		if t.Match.Regexp != nil && t.Match.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// flight is the context of a shared collection, canceled once all the
+// requests waiting for it are gone.
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// join adds a request waiting for the collection of key, until its context is
+// done or the returned function is called.
+func (s *Scraper) join(ctx context.Context, key string) (*flight, func()) {
+	s.flightsMutex.Lock()
+	f := s.flights[key]
+	if f == nil {
+		f = &flight{}
+		f.ctx, f.cancel = context.WithCancel(context.Background())
+		s.flights[key] = f
+	}
+	f.waiters++
+	s.flightsMutex.Unlock()
+
+	leave := func() {
+		s.flightsMutex.Lock()
+		defer s.flightsMutex.Unlock()
+		f.waiters--
+		if f.waiters > 0 {
+			return
+		}
+		f.cancel()
+		delete(s.flights, key)
+		// Later requests start a new collection instead of sharing the
+		// canceled one.
+		s.group.Forget(key)
+	}
+	stop := context.AfterFunc(ctx, leave)
+	return f, func() {
+		if stop() {
+			leave()
+		}
+	}
+}
+
+// scrapeObserver records the collections of a scraped target, under the
+// otherTarget label unless it is known.
+type scrapeObserver struct {
+	*Scraper
+	known bool
+}
+
+func (o scrapeObserver) ObserveCommand(server, command string, duration time.Duration, err error) {
+	if !o.known {
+		server = otherTarget
+	}
+	o.Scraper.ObserveCommand(server, command, duration, err)
+}
+
+func (o scrapeObserver) ObserveCollect(server string, duration time.Duration, err error) {
+	if !o.known {
+		server = otherTarget
+	}
+	o.Scraper.ObserveCollect(server, duration, err)
+}
+
 func (s *Scraper) Handler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Inc()
+		defer s.inFlight.Dec()
+
 		target := r.URL.Query().Get("target")
 		level.Debug(s.logger).Log("msg", "scrapping memcached", "target", target)
-		s.scrapeCount.Inc()
+		module := r.URL.Query().Get("module")
+		if !s.checkRequest(w, r, target, module) {
+			return
+		}
 
-		if target == "" {
-			errorStr := "'target' parameter must be specified"
-			level.Warn(s.logger).Log("msg", errorStr)
-			http.Error(w, errorStr, http.StatusBadRequest)
-			s.scrapeErrors.Inc()
+		// Concurrent requests for the same target and module share a single
+		// collection, each encoding the gathered metrics for its own client.
+		// It waits for the concurrency limits until all of them are gone.
+		names := r.URL.Query()["collect[]"]
+		sort.Strings(names)
+		key := strings.Join(append([]string{target, module}, names...), "\x00")
+		f, leave := s.join(r.Context(), key)
+		leader := false
+		result, err, _ := s.group.Do(key, func() (interface{}, error) {
+			leader = true
+			if err := s.limiter.acquire(f.ctx, target); err != nil {
+				return nil, err
+			}
+			defer s.limiter.release(target)
+			return s.gather(target, module, names)
+		})
+		leave()
+		if !leader {
+			label := target
+			if !s.known(target) {
+				label = otherTarget
+			}
+			s.coalesced.WithLabelValues(label).Inc()
+		}
+		var collectorErr *collectorError
+		if errors.As(err, &collectorErr) {
+			level.Warn(s.logger).Log("msg", "Invalid collect[] parameter", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.rejectQueued(w, target, err)
 			return
 		}
 
-		e := exporter.New(target, s.timeout, s.logger, s.tlsConfig)
-		registry := prometheus.NewRegistry()
-		registry.Register(e)
+		exposition.HandlerFor(result.(gathered), promhttp.HandlerOpts{
+			ErrorHandling: promhttp.ContinueOnError,
+		}, s.createdSamples).ServeHTTP(w, r)
+	}
+}
 
-		promhttp.HandlerFor(
-			registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError},
-		).ServeHTTP(w, r)
+// checkRequest checks the target and module parameters of a request. Invalid
+// requests are answered with 400 Bad Request, and rejected targets with 403
+// Forbidden.
+func (s *Scraper) checkRequest(w http.ResponseWriter, r *http.Request, target, module string) bool {
+	if target == "" {
+		errorStr := "'target' parameter must be specified"
+		level.Warn(s.logger).Log("msg", errorStr)
+		http.Error(w, errorStr, http.StatusBadRequest)
+		s.targetsRejected.WithLabelValues(rejectMissing).Inc()
+		return false
 	}
+
+	if _, err := exporter.ParseAddresses(target); err != nil {
+		level.Warn(s.logger).Log("msg", "Invalid target", "target", target, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.targetsRejected.WithLabelValues(rejectInvalid).Inc()
+		return false
+	}
+
+	if !s.allowTarget(w, r, target) {
+		return false
+	}
+
+	if _, ok := s.modules[module]; module != "" && !ok {
+		errorStr := fmt.Sprintf("unknown module %q", module)
+		level.Warn(s.logger).Log("msg", errorStr)
+		http.Error(w, errorStr, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// rejectQueued answers a request that could not acquire the concurrency limits
+// with 503 Service Unavailable.
+func (s *Scraper) rejectQueued(w http.ResponseWriter, target string, err error) {
+	reason := "queue_full"
+	if err != errQueueFull {
+		reason = "canceled"
+	}
+	level.Warn(s.logger).Log("msg", "Rejecting scrape", "target", target, "err", err)
+	s.rejected.WithLabelValues(reason).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+// exporterFor returns an exporter of target with the options of module, if not
+// empty, reporting its collections to observer.
+func (s *Scraper) exporterFor(target, module string, observer exporter.Observer) *exporter.Exporter {
+	opts := append([]exporter.Option{exporter.WithObserver(observer)}, s.exporterOptions...)
+	if module != "" {
+		opts = append(opts, exporter.WithModule(module, s.modules[module]))
+	}
+	return exporter.New(target, s.timeout, s.logger, s.tlsConfig, opts...)
+}
+
+// allowTarget checks target against the allowlist, if any. Rejected targets
+// are answered with 403 Forbidden.
+func (s *Scraper) allowTarget(w http.ResponseWriter, r *http.Request, target string) bool {
+	if s.allowlist == nil {
+		return true
+	}
+	// The target may list several addresses, like --memcached.address.
+	for _, address := range strings.Split(target, ",") {
+		if address == "" {
+			continue
+		}
+		var rejected *RejectedError
+		if err := s.allowlist.Check(r.Context(), address); errors.As(err, &rejected) {
+			level.Warn(s.logger).Log("msg", "Rejecting target", "target", target, "err", err)
+			s.targetsRejected.WithLabelValues(rejected.Reason).Inc()
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// retryAfter returns the number of seconds after which a rejected scrape may
+// be retried, which is about the time it takes to collect a target.
+func (s *Scraper) retryAfter() int {
+	return int(math.Max(math.Ceil(s.timeout.Seconds()), 1))
+}
+
+// gathered is the result of a collection, which can be gathered repeatedly.
+type gathered struct {
+	mfs []*dto.MetricFamily
+	err error
+}
+
+func (g gathered) Gather() ([]*dto.MetricFamily, error) {
+	return g.mfs, g.err
+}
+
+// collectorError is returned for collect[] parameters that do not name an
+// enabled collector.
+type collectorError struct {
+	err error
+}
+
+func (e *collectorError) Error() string {
+	return e.err.Error()
+}
+
+// gather collects the metrics of target with the options of module, if not
+// empty. If names is not empty, only the collectors it names are collected.
+func (s *Scraper) gather(target, module string, names []string) (gathered, error) {
+	e := s.exporterFor(target, module, scrapeObserver{Scraper: s, known: s.known(target)})
+	var c prometheus.Collector = e
+	if len(names) > 0 {
+		filtered, err := e.Filter(names)
+		if err != nil {
+			return gathered{}, &collectorError{err: err}
+		}
+		c = filtered
+	}
+	registry := prometheus.NewRegistry()
+	registry.Register(c)
+
+	mfs, err := registry.Gather()
+	return gathered{mfs: mfs, err: err}, nil
 }
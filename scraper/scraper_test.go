@@ -14,13 +14,22 @@
 package scraper
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tdewolff/memcached_exporter/config"
+	"github.com/tdewolff/memcached_exporter/internal/memcachedtest"
+	"github.com/tdewolff/memcached_exporter/pkg/exporter"
 )
 
 func TestHandler(t *testing.T) {
@@ -72,4 +81,443 @@ func TestHandler(t *testing.T) {
 			t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusBadRequest)
 		}
 	})
+
+	t.Run("Malformed pattern", func(t *testing.T) {
+		t.Parallel()
+
+		s := New(1*time.Second, log.NewNopLogger(), nil)
+
+		req, err := http.NewRequest("GET", "/?target="+url.QueryEscape("/run/memcached/[*.sock"), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(s.Handler())
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestSelfMetrics(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	// Unknown targets are recorded under a single label.
+	s := New(1*time.Second, log.NewNopLogger(), nil, WithTargets([]config.TargetConfig{
+		{Match: config.MustNewRegexp(regexp.QuoteMeta(server.Addr))},
+	}))
+	handler := http.HandlerFunc(s.Handler())
+
+	for _, target := range []string{server.Addr, "", "127.0.0.1:1"} {
+		req, err := http.NewRequest("GET", "/?target="+target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := `
+# HELP memcached_exporter_scrape_errors_total Count of memcached exporter scape errors by class of error.
+# TYPE memcached_exporter_scrape_errors_total counter
+memcached_exporter_scrape_errors_total{class="refused",target="other"} 1
+# HELP memcached_exporter_scrapes_in_flight Number of memcached exporter scrapes currently in progress.
+# TYPE memcached_exporter_scrapes_in_flight gauge
+memcached_exporter_scrapes_in_flight 0
+# HELP memcached_exporter_scrapes_total Count of memcached exporter scapes.
+# TYPE memcached_exporter_scrapes_total counter
+memcached_exporter_scrapes_total{target="other"} 1
+memcached_exporter_scrapes_total{target="SERVER"} 1
+# HELP memcached_exporter_targets_rejected_total Count of scrape requests rejected because the target is missing or not allowed.
+# TYPE memcached_exporter_targets_rejected_total counter
+memcached_exporter_targets_rejected_total{reason="missing"} 1
+`
+	expected = strings.ReplaceAll(expected, "SERVER", server.Addr)
+	names := []string{
+		"memcached_exporter_scrape_errors_total",
+		"memcached_exporter_scrapes_in_flight",
+		"memcached_exporter_scrapes_total",
+		"memcached_exporter_targets_rejected_total",
+	}
+	if err := testutil.CollectAndCompare(s, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	// Commands sent to the server are timed per target and command.
+	if n := testutil.CollectAndCount(s, "memcached_exporter_command_duration_seconds"); n != 6 {
+		t.Errorf("got %d command duration series, want 6", n)
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	stats := memcachedtest.Stats()
+	stats[""]["time"] = "1700003600"
+	server := memcachedtest.NewServer(t, stats)
+
+	for _, createdSamples := range []bool{false, true} {
+		s := New(1*time.Second, log.NewNopLogger(), nil, WithCreatedSamples(createdSamples))
+		handler := http.HandlerFunc(s.Handler())
+
+		req := httptest.NewRequest("GET", "/?target="+server.Addr, nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+			t.Fatalf("got content type %q, want OpenMetrics", contentType)
+		}
+
+		body := rr.Body.String()
+		if !strings.HasSuffix(body, "# EOF\n") {
+			t.Fatalf("response does not end with # EOF. body: %s", body)
+		}
+		samples := map[string]string{}
+		for _, line := range strings.Split(strings.TrimSuffix(body, "# EOF\n"), "\n") {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			i := strings.LastIndexByte(line, ' ')
+			if i < 0 {
+				t.Fatalf("invalid sample %q", line)
+			}
+			samples[line[:i]] = line[i+1:]
+		}
+
+		// Counters carry the start time of the server as created timestamp,
+		// served as a sample only if enabled.
+		series := `{server="` + server.Addr + `"}`
+		if v := samples["memcached_connections_total"+series]; v != "100.0" {
+			t.Errorf("got memcached_connections_total %q, want 100.0", v)
+		}
+		want := ""
+		if createdSamples {
+			want = "1.7e+09"
+		}
+		if v := samples["memcached_connections_created"+series]; v != want {
+			t.Errorf("created samples %v: got memcached_connections_created %q, want %q", createdSamples, v, want)
+		}
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil)
+	handler := http.HandlerFunc(s.Handler())
+
+	const requests = 5
+	release := server.Hold()
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/?target="+server.Addr, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if body := rr.Body.String(); !strings.Contains(body, "memcached_up{server=\""+server.Addr+"\"} 1") {
+				t.Errorf("handler could not inspect metrics. body: %s", body)
+			}
+		}()
+	}
+	for testutil.ToFloat64(s.inFlight) < requests {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	release()
+	wg.Wait()
+
+	if n := server.Connections(); n != 1 {
+		t.Errorf("got %d connections to the server, want 1", n)
+	}
+	if n := testutil.ToFloat64(s.coalesced.WithLabelValues(otherTarget)); n != requests-1 {
+		t.Errorf("got %v coalesced requests, want %d", n, requests-1)
+	}
+}
+
+func TestCoalescingLeaderGone(t *testing.T) {
+	busy := memcachedtest.NewServer(t, memcachedtest.Stats())
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil, WithLimits(1, 0, 1))
+	handler := http.HandlerFunc(s.Handler())
+
+	// A scrape of another server holds the only collection slot.
+	release := busy.Hold()
+	busyDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?target="+busy.Addr, nil))
+		close(busyDone)
+	}()
+	for testutil.ToFloat64(s.running) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The leader of the collection of server queues, then a follower joins it.
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?target="+server.Addr, nil).WithContext(ctx))
+		close(leaderDone)
+	}()
+	for testutil.ToFloat64(s.queued) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	rr := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr, nil))
+		close(followerDone)
+	}()
+	waiters := func() int {
+		s.flightsMutex.Lock()
+		defer s.flightsMutex.Unlock()
+		if f := s.flights[server.Addr+"\x00"]; f != nil {
+			return f.waiters
+		}
+		return 0
+	}
+	for waiters() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The collection keeps waiting for the follower once the leader is gone.
+	cancel()
+	for waiters() > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	<-busyDone
+	<-leaderDone
+	<-followerDone
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %d, want: %d. body: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if n := testutil.ToFloat64(s.rejected.WithLabelValues("canceled")); n != 0 {
+		t.Errorf("got %v canceled scrapes, want 0", n)
+	}
+
+	// Once all the requests are gone, the queued collection is canceled.
+	release = busy.Hold()
+	busyDone = make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?target="+busy.Addr, nil))
+		close(busyDone)
+	}()
+	for testutil.ToFloat64(s.running) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	rr = httptest.NewRecorder()
+	go cancel()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr, nil).WithContext(ctx))
+	release()
+	<-busyDone
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if n := testutil.ToFloat64(s.rejected.WithLabelValues("canceled")); n != 1 {
+		t.Errorf("got %v canceled scrapes, want 1", n)
+	}
+}
+
+func TestOverload(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil, WithLimits(1, 0, 0))
+	handler := http.HandlerFunc(s.Handler())
+
+	release := server.Hold()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?target="+server.Addr, nil))
+		close(done)
+	}()
+	for testutil.ToFloat64(s.running) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target=127.0.0.1:1", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("got Retry-After %q, want %q", retryAfter, "1")
+	}
+	release()
+	<-done
+
+	if n := testutil.ToFloat64(s.rejected.WithLabelValues("queue_full")); n != 1 {
+		t.Errorf("got %v rejected scrapes, want 1", n)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	s := New(1*time.Second, log.NewNopLogger(), nil, WithAllowlist(testAllowlist()))
+	handler := http.HandlerFunc(s.Handler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target=10.0.0.1:11211,169.254.169.254:11211", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusForbidden)
+	}
+	if n := testutil.ToFloat64(s.targetsRejected.WithLabelValues(rejectAddress)); n != 1 {
+		t.Errorf("got %v rejected targets, want 1", n)
+	}
+	// Allowed targets are not recorded under their own label, as the allowlist
+	// may allow any number of them.
+	if s.known("10.0.0.1:11211") {
+		t.Error("want allowed target to be unknown")
+	}
+}
+
+func TestKnownTargets(t *testing.T) {
+	s := New(1*time.Second, log.NewNopLogger(), nil,
+		WithStaticTargets([]string{"localhost:11211"}),
+		WithTargets([]config.TargetConfig{{Match: config.MustNewRegexp(`memcached-\d+:11211`)}}),
+	)
+	for target, known := range map[string]bool{
+		"localhost:11211":                     true,
+		"memcached-1:11211":                   true,
+		"memcached-2:11211":                   true,
+		"memcached-1:11211,memcached-2:11211": false,
+		"localhost:11211,memcached-1:11211":   false,
+		"10.0.0.1:11211":                      false,
+	} {
+		if got := s.known(target); got != known {
+			t.Errorf("known(%q) = %v, want %v", target, got, known)
+		}
+	}
+}
+
+func TestCollectParameter(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil)
+	handler := http.HandlerFunc(s.Handler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr+"&collect[]=settings", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusOK)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "memcached_max_connections") || strings.Contains(body, "memcached_current_connections") {
+		t.Errorf("handler did not filter collectors. body: %s", body)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr+"&collect[]=unknown", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestModule(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil, WithModules(map[string]config.ModuleConfig{
+		"small": {Slabs: &config.SlabsConfig{SizeBuckets: []uint64{128}}},
+	}))
+	handler := http.HandlerFunc(s.Handler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr+"&module=small", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusOK)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `memcached_slab_chunk_size_bytes{server="`+server.Addr+`",size_bucket="128"} 96`) {
+		t.Errorf("handler did not apply the module. body: %s", body)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr+"&module=unknown", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil)
+	handler := http.HandlerFunc(s.DebugHandler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusOK)
+	}
+	var debug []exporter.ServerStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &debug); err != nil {
+		t.Fatal(err)
+	}
+	if len(debug) != 1 || debug[0].Commands["stats"]["uptime"] != (exporter.StatsValue{Value: "3600", Status: exporter.KeyMapped}) {
+		t.Errorf("handler returned unexpected stats. body: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusBadRequest)
+	}
+
+	s = New(1*time.Second, log.NewNopLogger(), nil, WithAllowlist(testAllowlist()))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(s.DebugHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/?target=169.254.169.254:11211", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestDebugHandlerModule(t *testing.T) {
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil, WithModules(map[string]config.ModuleConfig{
+		"small": {Slabs: &config.SlabsConfig{Drop: []string{"memcached_slab_chunk_size_bytes"}}},
+	}))
+	handler := http.HandlerFunc(s.DebugHandler())
+
+	for module, status := range map[string]string{"": exporter.KeyMapped, "small": exporter.KeySkipped} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr+"&module="+module, nil))
+		var debug []exporter.ServerStats
+		if err := json.Unmarshal(rr.Body.Bytes(), &debug); err != nil {
+			t.Fatal(err)
+		}
+		if len(debug) != 1 || debug[0].Commands["stats slabs"]["1:chunk_size"].Status != status {
+			t.Errorf("module %q: want 1:chunk_size to be %s. body: %s", module, status, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr+"&module=unknown", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDebugHandlerLimits(t *testing.T) {
+	busy := memcachedtest.NewServer(t, memcachedtest.Stats())
+	server := memcachedtest.NewServer(t, memcachedtest.Stats())
+	s := New(1*time.Second, log.NewNopLogger(), nil, WithLimits(1, 0, 0))
+
+	// A scrape of another server holds the only collection slot, and there is
+	// no room in the queue.
+	release := busy.Hold()
+	done := make(chan struct{})
+	go func() {
+		http.HandlerFunc(s.Handler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?target="+busy.Addr, nil))
+		close(done)
+	}()
+	for testutil.ToFloat64(s.running) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.DebugHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/?target="+server.Addr, nil))
+	release()
+	<-done
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %d, want: %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if n := len(server.Commands()); n != 0 {
+		t.Errorf("got %d commands sent to the server, want 0", n)
+	}
 }